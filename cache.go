@@ -0,0 +1,621 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CacheStatusMiss response came from the server and was not served from cache
+	CacheStatusMiss = "MISS"
+	// CacheStatusHit response served from cache without contacting the server
+	CacheStatusHit = "HIT"
+	// CacheStatusRevalidated server answered 304 and the stored response was served
+	CacheStatusRevalidated = "REVALIDATED"
+)
+
+// heuristicExpirationMax upper bound for Last-Modified based freshness
+const heuristicExpirationMax = 24 * time.Hour
+
+// CacheStorage backend of the http cache
+type CacheStorage interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte) error
+	Delete(key string)
+}
+
+// CacheOptions http cache options, honoring Cache-Control, ETag and Last-Modified
+type CacheOptions struct {
+	Dir        string       `json:"dir" yaml:"dir" #:"磁盘缓存目录, 为空则使用内存缓存"`
+	MaxEntries int          `json:"max_entries" yaml:"max_entries" #:"内存缓存最大条目数, 0 不限制"`
+	Storage    CacheStorage `json:"-" yaml:"-"`
+}
+
+// Clone cache options, the storage is shared
+func (o *CacheOptions) Clone() *CacheOptions {
+	newOptions := *o
+	return &newOptions
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// Cache storage(s)
+//_______________________________________________________________________
+
+// MemoryCacheStorage in-memory cache storage
+type MemoryCacheStorage struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	items map[string][]byte
+	order []string
+}
+
+// NewMemoryCacheStorage maxEntries 0 means unlimited, oldest entries are evicted first
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	return &MemoryCacheStorage{
+		maxEntries: maxEntries,
+		items:      make(map[string][]byte),
+	}
+}
+
+func (s *MemoryCacheStorage) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.items[key]
+	return value, ok
+}
+
+func (s *MemoryCacheStorage) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.items[key] = value
+	for s.maxEntries > 0 && len(s.items) > s.maxEntries {
+		delete(s.items, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+func (s *MemoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[key]; !ok {
+		return
+	}
+	delete(s.items, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// DiskCacheStorage stores one file per entry in Dir
+type DiskCacheStorage struct {
+	Dir string
+}
+
+// NewDiskCacheStorage dir is created on first write
+func NewDiskCacheStorage(dir string) *DiskCacheStorage {
+	return &DiskCacheStorage{Dir: dir}
+}
+
+func (s *DiskCacheStorage) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *DiskCacheStorage) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (s *DiskCacheStorage) Set(key string, value []byte) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(s.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(value); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	// rename 保证并发读不会读到写了一半的文件
+	return os.Rename(f.Name(), s.path(key))
+}
+
+func (s *DiskCacheStorage) Delete(key string) {
+	os.Remove(s.path(key))
+}
+
+//‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
+// Cache
+//_______________________________________________________________________
+
+// cacheEntry stored response, serialized as json
+type cacheEntry struct {
+	Status       string            `json:"status"`
+	StatusCode   int               `json:"status_code"`
+	Proto        string            `json:"proto"`
+	ProtoMajor   int               `json:"proto_major"`
+	ProtoMinor   int               `json:"proto_minor"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"request_time"`
+	ResponseTime time.Time         `json:"response_time"`
+	Vary         map[string]string `json:"vary"`
+	// Credentials hash of the Authorization and Cookie headers the response was fetched with
+	Credentials string `json:"credentials"`
+	// Wire and Anomalies of a leniently parsed response
+	Wire      []byte   `json:"wire,omitempty"`
+	Anomalies []string `json:"anomalies,omitempty"`
+}
+
+// httpCache private cache (RFC 9111) in front of Client.do
+type httpCache struct {
+	storage CacheStorage
+}
+
+func newHTTPCache(options *CacheOptions) *httpCache {
+	storage := options.Storage
+	if storage == nil {
+		if options.Dir != "" {
+			storage = NewDiskCacheStorage(options.Dir)
+		} else {
+			storage = NewMemoryCacheStorage(options.MaxEntries)
+		}
+	}
+	return &httpCache{storage: storage}
+}
+
+func (hc *httpCache) do(ctx context.Context, req *Request, fn func(context.Context, *Request) (*Response, error)) (*Response, error) {
+	key := cacheKey(req.GetUrl(), req)
+	if req.GetMethod() != MethodGet {
+		resp, err := fn(ctx, req)
+		// unsafe methods invalidate the stored response of the target uri,
+		// the entries of other credentials can't be reached
+		if err == nil && !isSafeMethod(req.GetMethod()) && resp.GetStatus() < 400 {
+			hc.storage.Delete(key)
+			hc.storage.Delete(normalizeURL(req.GetUrl()))
+			if location := resp.GetHeaders().Get("Location"); location != "" {
+				if u, err := req.GetUrl().Parse(location); err == nil && u.Host == req.GetHost() {
+					hc.storage.Delete(cacheKey(u, req))
+					hc.storage.Delete(normalizeURL(u))
+				}
+			}
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.GetHeaders())
+	if len(reqCC) == 0 && strings.Contains(strings.ToLower(req.GetHeaders().Get("Pragma")), "no-cache") {
+		reqCC["no-cache"] = ""
+	}
+	userConditional := req.GetHeaders().Get("If-None-Match") != "" || req.GetHeaders().Get("If-Modified-Since") != ""
+	if _, ok := reqCC["no-store"]; ok || userConditional || req.GetHeaders().Get("Range") != "" {
+		return fn(ctx, req)
+	}
+
+	entry := hc.load(key, req)
+	if entry != nil {
+		if hc.fresh(entry, reqCC) {
+			req.setSendAt()
+			return entry.response(req, CacheStatusHit), nil
+		}
+	}
+	if _, ok := reqCC["only-if-cached"]; ok {
+		return gatewayTimeoutResponse(req), nil
+	}
+
+	// the validators go on a copy, the caller's request stays as it was
+	sent, conditional := req, false
+	if entry != nil {
+		etag, lastModified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			sent, conditional = req.Clone(), true
+			sent.trace = req.trace
+		}
+		if etag != "" {
+			sent.SetHeader("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			sent.SetHeader("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := time.Now()
+	resp, err := fn(ctx, sent)
+	if err != nil {
+		return nil, err
+	}
+	sameURL := normalizeURL(resp.GetUrl()) == normalizeURL(req.GetUrl())
+	if conditional && sameURL && resp.GetStatus() == http.StatusNotModified {
+		entry.update(resp, requestTime)
+		hc.store(key, entry, req)
+		// the caller gets its own request back, with what sending the copy recorded
+		req.sendAt, req.attempt, req.raw = sent.sendAt, sent.attempt, sent.raw
+		req.clientTrace, req.connState = sent.clientTrace, sent.connState
+		req.localAddr, req.remoteAddr = sent.localAddr, sent.remoteAddr
+		cached := entry.response(req, CacheStatusRevalidated)
+		cached.receivedAt = resp.receivedAt
+		cached.redirects, cached.tlsVerify, cached.tlsInfo = resp.redirects, resp.tlsVerify, resp.tlsInfo
+		cached.RawResponse.TLS = resp.RawResponse.TLS
+		return cached, nil
+	}
+
+	resp.cacheStatus = CacheStatusMiss
+	if sameURL && storable(resp) {
+		hc.store(key, newCacheEntry(req, resp, requestTime), req)
+	} else if sameURL && entry != nil {
+		hc.storage.Delete(key)
+	}
+	return resp, nil
+}
+
+// cacheKey responses fetched with other credentials are stored apart, the
+// anonymous key is the normalized url
+func cacheKey(u *url.URL, req *Request) string {
+	key := normalizeURL(u)
+	if credentials := credentialsHash(req); credentials != "" {
+		key += "\n" + credentials
+	}
+	return key
+}
+
+// variantKey key of the variant selected by the Vary headers of entry for req,
+// the latest variant is also stored under key
+func variantKey(key string, entry *cacheEntry, req *Request) string {
+	names := make([]string, 0, len(entry.Vary))
+	for name := range entry.Vary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range names {
+		b.WriteString("\n" + name + ": " + strings.Join(req.GetHeaders().Values(name), ","))
+	}
+	return b.String()
+}
+
+// load returns the stored entry if its Vary headers and credentials match req,
+// falling back to the variant of req when the latest one doesn't
+func (hc *httpCache) load(key string, req *Request) *cacheEntry {
+	entry := hc.get(key)
+	if entry != nil && !entry.matchVary(req) {
+		entry = hc.get(variantKey(key, entry, req))
+		if entry != nil && !entry.matchVary(req) {
+			return nil
+		}
+	}
+	// RFC 9111 section 3.5, never served to or revalidated for other credentials
+	if entry == nil || entry.Credentials != credentialsHash(req) {
+		return nil
+	}
+	return entry
+}
+
+func (hc *httpCache) get(key string) *cacheEntry {
+	data, ok := hc.storage.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		hc.storage.Delete(key)
+		return nil
+	}
+	return entry
+}
+
+// credentialsHash empty when req carries neither Authorization nor Cookie
+func credentialsHash(req *Request) string {
+	authorization := strings.Join(req.GetHeaders().Values("Authorization"), ",")
+	cookie := strings.Join(req.GetHeaders().Values("Cookie"), ";")
+	if authorization == "" && cookie == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization + "\n" + cookie))
+	return hex.EncodeToString(sum[:])
+}
+
+// store entry under key and, when it varies, under the key of its variant
+func (hc *httpCache) store(key string, entry *cacheEntry, req *Request) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// 缓存写入失败不影响请求结果
+	_ = hc.storage.Set(key, data)
+	if len(entry.Vary) > 0 {
+		_ = hc.storage.Set(variantKey(key, entry, req), data)
+	}
+}
+
+// fresh applies response and request Cache-Control directives
+func (hc *httpCache) fresh(entry *cacheEntry, reqCC map[string]string) bool {
+	respCC := parseCacheControl(entry.Header)
+	if _, ok := respCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+
+	lifetime := entry.freshnessLifetime()
+	age := entry.currentAge(time.Now())
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil && time.Duration(maxAge)*time.Second < lifetime {
+			lifetime = time.Duration(maxAge) * time.Second
+		}
+	}
+	if v, ok := reqCC["min-fresh"]; ok {
+		if minFresh, err := strconv.Atoi(v); err == nil {
+			age += time.Duration(minFresh) * time.Second
+		}
+	}
+	if age < lifetime {
+		return true
+	}
+
+	_, mustRevalidate := respCC["must-revalidate"]
+	if v, ok := reqCC["max-stale"]; ok && !mustRevalidate {
+		if v == "" {
+			return true
+		}
+		if maxStale, err := strconv.Atoi(v); err == nil {
+			return age < lifetime+time.Duration(maxStale)*time.Second
+		}
+	}
+	return false
+}
+
+func newCacheEntry(req *Request, resp *Response, requestTime time.Time) *cacheEntry {
+	entry := &cacheEntry{
+		Status:       resp.RawResponse.Status,
+		StatusCode:   resp.RawResponse.StatusCode,
+		Proto:        resp.RawResponse.Proto,
+		ProtoMajor:   resp.RawResponse.ProtoMajor,
+		ProtoMinor:   resp.RawResponse.ProtoMinor,
+		Header:       resp.GetHeaders().Clone(),
+		Body:         resp.GetBody(),
+		RequestTime:  requestTime,
+		ResponseTime: resp.receivedAt,
+		Vary:         make(map[string]string),
+		Credentials:  credentialsHash(req),
+		Wire:         resp.wire,
+		Anomalies:    resp.anomalies,
+	}
+	for _, name := range varyHeaders(resp.GetHeaders()) {
+		entry.Vary[name] = strings.Join(req.GetHeaders().Values(name), ",")
+	}
+	return entry
+}
+
+// matchVary whether req carries the Vary headers the entry was fetched with
+func (e *cacheEntry) matchVary(req *Request) bool {
+	for name, value := range e.Vary {
+		if strings.Join(req.GetHeaders().Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// update merges the headers of a 304 into the stored response
+func (e *cacheEntry) update(resp *Response, requestTime time.Time) {
+	for name, values := range resp.GetHeaders() {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range":
+			continue
+		}
+		e.Header[name] = append([]string(nil), values...)
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = resp.receivedAt
+}
+
+// response materializes the entry into a Response for req
+func (e *cacheEntry) response(req *Request, cacheStatus string) *Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.currentAge(time.Now())/time.Second), 10))
+	body := append([]byte(nil), e.Body...)
+	resp := &Response{
+		Request: req,
+		RawResponse: &http.Response{
+			Status:        e.Status,
+			StatusCode:    e.StatusCode,
+			Proto:         e.Proto,
+			ProtoMajor:    e.ProtoMajor,
+			ProtoMinor:    e.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req.RawRequest,
+		},
+		Body:        body,
+		cacheStatus: cacheStatus,
+		wire:        append([]byte(nil), e.Wire...),
+		anomalies:   append([]string(nil), e.Anomalies...),
+	}
+	resp.setReceivedAt()
+	return resp
+}
+
+// freshnessLifetime max-age, then Expires, then the Last-Modified heuristic
+func (e *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if v, ok := cc["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil {
+			return time.Duration(maxAge) * time.Second
+		}
+		return 0
+	}
+	date := e.date()
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if !heuristicallyCacheable(e.StatusCode) {
+		return 0
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && lastModified.Before(date) {
+		lifetime := date.Sub(lastModified) / 10
+		if lifetime > heuristicExpirationMax {
+			lifetime = heuristicExpirationMax
+		}
+		return lifetime
+	}
+	return 0
+}
+
+// currentAge RFC 9111 section 4.2.3
+func (e *cacheEntry) currentAge(now time.Time) time.Duration {
+	apparentAge := e.ResponseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if age, err := strconv.Atoi(e.Header.Get("Age")); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// storable decides whether resp may be stored by a private cache
+func storable(resp *Response) bool {
+	respCC := parseCacheControl(resp.GetHeaders())
+	if _, ok := respCC["no-store"]; ok {
+		return false
+	}
+	for _, name := range varyHeaders(resp.GetHeaders()) {
+		if name == "*" {
+			return false
+		}
+	}
+	// 响应被 MaxRespBodySize 截断时不缓存
+	if cl := resp.RawResponse.ContentLength; cl >= 0 && int64(len(resp.GetBody())) != cl {
+		return false
+	}
+	if resp.GetHeaders().Get("ETag") != "" || resp.GetHeaders().Get("Last-Modified") != "" {
+		return true
+	}
+	if _, ok := respCC["max-age"]; ok {
+		return true
+	}
+	if resp.GetHeaders().Get("Expires") != "" {
+		return true
+	}
+	return false
+}
+
+func heuristicallyCacheable(status int) bool {
+	switch status {
+	case 200, 203, 204, 206, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case MethodGet, MethodHead, MethodOptions, MethodTrace:
+		return true
+	}
+	return false
+}
+
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// parseCacheControl directive names are lower cased, quoted values unquoted
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// gatewayTimeoutResponse answer to only-if-cached when nothing usable is stored
+func gatewayTimeoutResponse(req *Request) *Response {
+	resp := &Response{
+		Request: req,
+		RawResponse: &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req.RawRequest,
+		},
+		Body:        []byte{},
+		cacheStatus: CacheStatusMiss,
+	}
+	req.setSendAt()
+	resp.setReceivedAt()
+	return resp
+}
@@ -0,0 +1,174 @@
+package shttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache_Conditional(t *testing.T) {
+	var hits, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("static resource"))
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("fresh"))
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+			w.Write([]byte("secret"))
+		}
+	}))
+	defer ts.Close()
+
+	for _, cacheOptions := range []*CacheOptions{{}, {Dir: t.TempDir()}} {
+		atomic.StoreInt32(&hits, 0)
+		atomic.StoreInt32(&notModified, 0)
+		options := DefaultClientOptions()
+		options.Cache = cacheOptions
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+		get := func(path string) *Response {
+			hr, _ := http.NewRequest("GET", ts.URL+path, nil)
+			resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+			require.Nil(t, err)
+			return resp
+		}
+
+		resp := get("/etag")
+		require.Equal(t, CacheStatusMiss, resp.GetCacheStatus())
+		resp = get("/etag")
+		require.Equal(t, CacheStatusRevalidated, resp.GetCacheStatus())
+		require.Equal(t, 200, resp.GetStatus())
+		require.Equal(t, "static resource", string(resp.GetBody()))
+		require.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+		// the caller's request, sent on the copy's connection
+		require.Empty(t, resp.Request.GetHeaders().Get("If-None-Match"))
+		require.NotNil(t, resp.GetLocalAddr())
+		require.Equal(t, ts.Listener.Addr().String(), resp.GetRemoteAddr().String())
+
+		get("/fresh")
+		resp = get("/fresh")
+		require.Equal(t, CacheStatusHit, resp.GetCacheStatus())
+		require.Equal(t, "fresh", string(resp.GetBody()))
+
+		get("/no-store")
+		resp = get("/no-store")
+		require.Equal(t, CacheStatusMiss, resp.GetCacheStatus())
+		require.Equal(t, int32(5), atomic.LoadInt32(&hits))
+
+		// request directives bypass a fresh entry
+		hr, _ := http.NewRequest("GET", ts.URL+"/fresh", nil)
+		hr.Header.Set("Cache-Control", "no-cache")
+		resp, err = client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, CacheStatusMiss, resp.GetCacheStatus())
+		require.Equal(t, int32(6), atomic.LoadInt32(&hits))
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Cache = &CacheOptions{}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for _, method := range []string{"GET", "GET", "POST", "GET"} {
+		hr, _ := http.NewRequest(method, ts.URL+"/item", nil)
+		_, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
+
+func TestCache_Credentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("user " + r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Cache = &CacheOptions{}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	get := func(authorization string) (*Request, *Response) {
+		hr, _ := http.NewRequest("GET", ts.URL+"/private", nil)
+		if authorization != "" {
+			hr.Header.Set("Authorization", authorization)
+		}
+		req := &Request{RawRequest: hr}
+		resp, err := client.Do(context.Background(), req)
+		require.Nil(t, err)
+		return req, resp
+	}
+
+	_, resp := get("a")
+	require.Equal(t, CacheStatusMiss, resp.GetCacheStatus())
+	req, resp := get("a")
+	require.Equal(t, CacheStatusRevalidated, resp.GetCacheStatus())
+	require.Equal(t, "user a", string(resp.GetBody()))
+	require.Same(t, req, resp.Request)
+	require.Empty(t, req.GetHeaders().Get("If-None-Match"), "validators are sent on a copy")
+
+	// another user's entry is neither served nor revalidated
+	for _, authorization := range []string{"b", ""} {
+		_, resp = get(authorization)
+		require.Equal(t, CacheStatusMiss, resp.GetCacheStatus())
+		require.Equal(t, "user "+authorization, string(resp.GetBody()))
+	}
+	// and didn't replace the first one
+	_, resp = get("a")
+	require.Equal(t, CacheStatusRevalidated, resp.GetCacheStatus())
+	require.Equal(t, "user a", string(resp.GetBody()))
+}
+
+func TestCache_Vary(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Cache = &CacheOptions{}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for i, language := range []string{"en", "fr", "en", "fr"} {
+		hr, _ := http.NewRequest("GET", ts.URL+"/page", nil)
+		hr.Header.Set("Accept-Language", language)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, language, string(resp.GetBody()))
+		if i >= 2 {
+			require.Equal(t, CacheStatusHit, resp.GetCacheStatus(), language)
+		}
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
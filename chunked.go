@@ -0,0 +1,107 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Chunk one chunk of a chunked body
+type Chunk struct {
+	Data      []byte
+	Size      string // size line, the hex length of Data when empty, anything else makes malformed framing
+	Extension string // chunk extension after the size, without the ';'
+}
+
+// ChunkedBody request body sent with Transfer-Encoding: chunked exactly as
+// described, for waf evasion and smuggling checks. Requests with a chunked
+// body always go over http/1.1 on a connection of their own and aren't redirected.
+type ChunkedBody struct {
+	Chunks           []Chunk
+	LastExtension    string   // chunk extension of the last, zero size, chunk
+	Trailers         []string // "Name: value" lines after the last chunk, written as is
+	NoLastChunk      bool     // the body ends after the chunks, the server waits for the rest
+	TransferEncoding string   // value of the Transfer-Encoding header, chunked when empty
+	Raw              []byte   // the whole body as is instead of the chunks, for framing the fields can't express
+}
+
+// NewChunkedBody data split into chunks of size bytes
+func NewChunkedBody(data []byte, size int) *ChunkedBody {
+	body := &ChunkedBody{}
+	if size <= 0 {
+		size = len(data)
+	}
+	for len(data) > 0 {
+		n := min(size, len(data))
+		body.Chunks = append(body.Chunks, Chunk{Data: data[:n]})
+		data = data[n:]
+	}
+	return body
+}
+
+// Bytes wire form of the body
+func (b *ChunkedBody) Bytes() []byte {
+	if b.Raw != nil {
+		return b.Raw
+	}
+	var buf bytes.Buffer
+	writeLine := func(size, extension string) {
+		buf.WriteString(size)
+		if extension != "" {
+			buf.WriteString(";" + extension)
+		}
+		buf.WriteString("\r\n")
+	}
+	for _, chunk := range b.Chunks {
+		size := chunk.Size
+		if size == "" {
+			size = strconv.FormatInt(int64(len(chunk.Data)), 16)
+		}
+		writeLine(size, chunk.Extension)
+		buf.Write(chunk.Data)
+		buf.WriteString("\r\n")
+	}
+	if b.NoLastChunk {
+		return buf.Bytes()
+	}
+	writeLine("0", b.LastExtension)
+	for _, trailer := range b.Trailers {
+		buf.WriteString(trailer + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// Payload data of the chunks, nil for a raw body
+func (b *ChunkedBody) Payload() []byte {
+	if b.Raw != nil {
+		return nil
+	}
+	var payload []byte
+	for _, chunk := range b.Chunks {
+		payload = append(payload, chunk.Data...)
+	}
+	return payload
+}
+
+// serializeChunked wire bytes of req with its chunked body
+func serializeChunked(ctx context.Context, req *http.Request, body *ChunkedBody) ([]byte, error) {
+	rawReq := req.Clone(ctx)
+	// one byte keeps net/http from dropping the chunked framing of bodiless methods
+	rawReq.Body = io.NopCloser(strings.NewReader("x"))
+	rawReq.ContentLength = -1
+	rawReq.TransferEncoding = []string{"chunked"}
+	var buf bytes.Buffer
+	if err := rawReq.Write(&buf); err != nil {
+		return nil, err
+	}
+	head := buf.Bytes()[:bytes.Index(buf.Bytes(), []byte("\r\n\r\n"))+2]
+	if body.TransferEncoding != "" {
+		head = bytes.Replace(head, []byte("Transfer-Encoding: chunked\r\n"), []byte("Transfer-Encoding: "+body.TransferEncoding+"\r\n"), 1)
+	}
+	raw := append(head, "\r\n"...)
+	return append(raw, body.Bytes()...), nil
+}
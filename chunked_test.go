@@ -0,0 +1,71 @@
+package shttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequest_SetChunkedBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(strings.Join(r.TransferEncoding, ",") + "|" + string(body) + "|" + r.Trailer.Get("X-Sum")))
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+
+	body := NewChunkedBody([]byte("hello world"), 4)
+	require.Len(t, body.Chunks, 3)
+	body.Chunks[0].Extension = "ext=1"
+	body.Trailers = []string{"X-Sum: 11"}
+	require.Equal(t, "4;ext=1\r\nhell\r\n4\r\no wo\r\n3\r\nrld\r\n0\r\nX-Sum: 11\r\n\r\n", string(body.Bytes()))
+	require.Equal(t, "hello world", string(body.Payload()))
+
+	for _, target := range []string{ts.URL, secure.URL} {
+		hr, _ := http.NewRequest("POST", target, nil)
+		req := (&Request{RawRequest: hr}).SetChunkedBody(body)
+		resp, err := client.Do(context.Background(), req)
+		require.Nil(t, err)
+		require.Equal(t, "chunked|hello world|11", string(resp.GetBody()))
+		raw, err := req.GetRaw()
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(string(raw), "POST / HTTP/1.1\r\n"))
+		require.True(t, strings.HasSuffix(string(raw), "Transfer-Encoding: chunked\r\n\r\n"+string(body.Bytes())))
+		require.NotNil(t, resp.GetRemoteAddr())
+	}
+
+	// bodiless methods keep the framing too
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	req := (&Request{RawRequest: hr}).SetChunkedBody(NewChunkedBody([]byte("get"), 0))
+	resp, err := client.Do(context.Background(), req)
+	require.Nil(t, err)
+	require.Equal(t, "chunked|get|", string(resp.GetBody()))
+
+	// malformed size line
+	hr, _ = http.NewRequest("POST", ts.URL, nil)
+	req = (&Request{RawRequest: hr}).SetChunkedBody(&ChunkedBody{Chunks: []Chunk{{Data: []byte("hello"), Size: "zz"}}})
+	resp, err = client.Do(context.Background(), req)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.GetStatus())
+
+	body = &ChunkedBody{Raw: []byte("5\r\nhello\r\n0\r\n\r\n"), TransferEncoding: "chunked, identity"}
+	hr, _ = http.NewRequest("POST", ts.URL, nil)
+	req = (&Request{RawRequest: hr}).SetChunkedBody(body)
+	raw, err := req.GetRaw()
+	require.Nil(t, err)
+	require.Contains(t, string(raw), "Transfer-Encoding: chunked, identity\r\n\r\n5\r\nhello\r\n0\r\n\r\n")
+	require.NotContains(t, string(raw), "Content-Length")
+}
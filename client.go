@@ -2,14 +2,15 @@ package shttp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/iami317/shttp/xtls"
-	"golang.org/x/net/http2"
 	"golang.org/x/net/publicsuffix"
 	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/url"
 	"time"
 )
@@ -36,8 +37,17 @@ type Client struct {
 	extraBeforeRequest   []RequestMiddleware
 	afterResponse        []ResponseMiddleware
 	errorHooks           []errorHook
+	dedup                *dedupGroup
+	cache                *httpCache
+	scope                *scope
+	followRedirects      bool
+	tlsVerifier          *xtls.Verifier
+	handshaker           *xtls.Handshaker
+	dialer               *dialer
+	sessionDial          DialFunc // connection of the session, to the target or the proxy, see doDirect
 
 	// handle
+	// Deprecated: never set, the address of every connection is on its response, see Response.GetLocalAddr
 	LocalAddress    *net.TCPAddr
 	closeConnection bool
 }
@@ -49,8 +59,7 @@ func NewClient(options *ClientOptions, jar *cookiejar.Jar) (*Client, error) {
 		return nil, err
 	}
 
-	client := createClient(options, hc)
-	return client, nil
+	return createClient(options, hc, false)
 }
 
 // NewRedirectClient xhttp.Client with Redirect
@@ -60,8 +69,7 @@ func NewRedirectClient(options *ClientOptions, jar *cookiejar.Jar) (*Client, err
 		return nil, err
 	}
 
-	client := createClient(options, hc)
-	return client, nil
+	return createClient(options, hc, true)
 }
 
 // NewDefaultClient xhttp.Client not follow redirect
@@ -71,8 +79,7 @@ func NewDefaultClient(jar *cookiejar.Jar) (*Client, error) {
 		return nil, err
 	}
 
-	client := createClient(DefaultClientOptions(), hc)
-	return client, nil
+	return createClient(DefaultClientOptions(), hc, false)
 }
 
 // NewDefaultRedirectClient follow redirect
@@ -82,45 +89,38 @@ func NewDefaultRedirectClient(jar *cookiejar.Jar) (*Client, error) {
 		return nil, err
 	}
 
-	client := createClient(DefaultClientOptions(), hc)
-	return client, nil
+	return createClient(DefaultClientOptions(), hc, true)
 }
 
 // NewWithHTTPClient with http client
 func NewWithHTTPClient(options *ClientOptions, hc *http.Client) (*Client, error) {
-	return createClient(options, hc), nil
+	return createClient(options, hc, hc.CheckRedirect == nil)
 }
 
 // Do request
 func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
-	var (
-		resp                 *http.Response
-		shouldRetry          bool
-		err, doErr, retryErr error
-	)
 	if c == nil {
 		return nil, errors.New("xhttp client not instantiated")
 	}
+	if c.dedup != nil {
+		return c.dedup.do(ctx, req, c.doCache)
+	}
+	return c.doCache(ctx, req)
+}
 
-	if c.ClientOptions.SoloConn {
-		tlsClientConfig, _ := xtls.NewTLSConfig(c.ClientOptions.TlsOptions)
-		c.HTTPClient.Transport = &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				conn, err := net.Dial("tcp", addr)
-				if conn != nil {
-					c.LocalAddress = conn.LocalAddr().(*net.TCPAddr)
-				}
-				return conn, err
-			},
-			MaxConnsPerHost:       c.ClientOptions.MaxConnsPerHost,
-			ResponseHeaderTimeout: time.Duration(c.ClientOptions.ReadTimeout) * time.Second,
-			IdleConnTimeout:       time.Duration(c.ClientOptions.IdleConnTimeout) * time.Second,
-			TLSHandshakeTimeout:   time.Duration(c.ClientOptions.TLSHandshakeTimeout) * time.Second,
-			MaxIdleConns:          c.ClientOptions.MaxIdleConns,
-			TLSClientConfig:       tlsClientConfig,
-			DisableKeepAlives:     c.ClientOptions.DisableKeepAlives,
-		}
+func (c *Client) doCache(ctx context.Context, req *Request) (*Response, error) {
+	if c.cache != nil {
+		return c.cache.do(ctx, req, c.doBrowserRedirect)
 	}
+	return c.doBrowserRedirect(ctx, req)
+}
+
+func (c *Client) do(ctx context.Context, req *Request) (*Response, error) {
+	var (
+		resp                 *http.Response
+		shouldRetry          bool
+		err, doErr, retryErr error
+	)
 
 	req.SetContext(ctx)
 	req.attempt = 0
@@ -148,7 +148,18 @@ func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
 		req.attempt++
 
 		req.setSendAt()
-		resp, doErr = c.HTTPClient.Do(req.RawRequest)
+		req.resetRedirects()
+		req.direct = false
+		switch {
+		case req.chunked != nil:
+			resp, doErr = c.doDirect(req)
+		case c.ClientOptions.LenientResponse:
+			hc := *c.HTTPClient
+			hc.Transport = &lenientTransport{client: c, req: req, next: c.HTTPClient.Transport}
+			resp, doErr = hc.Do(req.RawRequest)
+		default:
+			resp, doErr = c.HTTPClient.Do(req.RawRequest)
+		}
 		// need retry
 		shouldRetry, retryErr = defaultRetryPolicy(req.GetContext(), resp, doErr)
 		if !shouldRetry {
@@ -174,8 +185,17 @@ func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
 		response := &Response{
 			Request:     req,
 			RawResponse: resp,
+			redirects:   req.redirects,
+		}
+		if req.lenient != nil {
+			response.wire, response.anomalies = req.lenient.raw, req.lenient.anomalies
 		}
 		response.setReceivedAt()
+		response.setTLSInfo()
+		if err = c.verifyTLS(response, resp.Request.URL.Hostname()); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
 
 		for _, f := range c.afterResponse {
 			if err = f(response, c); err != nil {
@@ -189,7 +209,7 @@ func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
 			finalErr = retryErr
 		}
 		//logx.Debugf("%s %s fail", req.GetMethod(), req.GetUrl().String())
-		return nil, fmt.Errorf("giving up connect to %s %s after %d attempt(s): %v",
+		return nil, fmt.Errorf("giving up connect to %s %s after %d attempt(s): %w",
 			req.RawRequest.Method, req.RawRequest.URL, req.attempt, finalErr)
 	}
 }
@@ -229,6 +249,13 @@ func (c *Client) tryBestClone() *Client {
 	for i, value := range c.errorHooks {
 		newClient.errorHooks[i] = value
 	}
+	// 行为可能不同（跳转、cookie），不共享合并及缓存, 指定 Dir 或 Storage 时缓存存储仍是共享的
+	if newClient.ClientOptions.Dedup != nil {
+		newClient.dedup = newDedupGroup(newClient.ClientOptions.Dedup)
+	}
+	if newClient.ClientOptions.Cache != nil {
+		newClient.cache = newHTTPCache(newClient.ClientOptions.Cache)
+	}
 	return &newClient
 }
 
@@ -240,27 +267,56 @@ func (c *Client) WithoutCookieJar() *Client {
 
 func (c *Client) WithRedirect(redirect bool) *Client {
 	newClient := c.tryBestClone()
-	newClient.HTTPClient.CheckRedirect = makeCheckRedirectFunc(redirect, c.ClientOptions.MaxRedirect)
+	newClient.HTTPClient.CheckRedirect = makeCheckRedirectFunc(redirect, c.ClientOptions, c.scope)
+	newClient.followRedirects = redirect
 	return newClient
 }
 
-func createClient(options *ClientOptions, hc *http.Client) *Client {
+func createClient(options *ClientOptions, hc *http.Client, followRedirects bool) (*Client, error) {
+	if err := verifyProtocol(options.Protocol); err != nil {
+		return nil, err
+	}
+	s, err := newScope(options.Scope)
+	if err != nil {
+		return nil, err
+	}
 	c := &Client{
-		HTTPClient:    hc,
-		ClientOptions: options,
-		Debug:         options.Debug,
+		HTTPClient:      hc,
+		ClientOptions:   options,
+		Debug:           options.Debug,
+		scope:           s,
+		followRedirects: followRedirects,
+	}
+	// connections of our own are direct, the scope always applies
+	if c.dialer, err = newDialer(options, s); err != nil {
+		return nil, err
+	}
+	if options.Dedup != nil {
+		c.dedup = newDedupGroup(options.Dedup)
+	}
+	if options.Cache != nil {
+		c.cache = newHTTPCache(options.Cache)
+	}
+	if options.TlsOptions != nil {
+		if c.tlsVerifier, err = xtls.NewVerifier(options.TlsOptions); err != nil {
+			return nil, err
+		}
+		if c.handshaker, err = xtls.NewHandshaker(options.TlsOptions); err != nil {
+			return nil, err
+		}
 	}
 
 	c.extraBeforeRequest = []RequestMiddleware{}
 	c.defaultBeforeRequest = []RequestMiddleware{
 		verifyRequestMethod,
+		verifyRequestScope,
 		createHTTPRequest,
 	}
 	c.afterResponse = []ResponseMiddleware{
 		readResponseBody,
 		//responseLogger,
 	}
-	return c
+	return c, nil
 }
 
 func GetFreePort() int {
@@ -278,37 +334,22 @@ func GetFreePort() int {
 }
 
 func createHttpClient(httpClientOptions *ClientOptions, followRedirects bool, jar *cookiejar.Jar) (*http.Client, error) {
-	tlsClientConfig, err := xtls.NewTLSConfig(httpClientOptions.TlsOptions)
+	s, err := newScope(httpClientOptions.Scope)
 	if err != nil {
 		return nil, err
 	}
-
-	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout: time.Duration(httpClientOptions.DialTimeout) * time.Second,
-		}).DialContext,
-		MaxConnsPerHost:       httpClientOptions.MaxConnsPerHost,
-		ResponseHeaderTimeout: time.Duration(httpClientOptions.ReadTimeout) * time.Second,
-		IdleConnTimeout:       time.Duration(httpClientOptions.IdleConnTimeout) * time.Second,
-		TLSHandshakeTimeout:   time.Duration(httpClientOptions.TLSHandshakeTimeout) * time.Second,
-		MaxIdleConns:          httpClientOptions.MaxIdleConns,
-		TLSClientConfig:       tlsClientConfig,
-		DisableKeepAlives:     httpClientOptions.DisableKeepAlives,
+	d, err := newDialer(httpClientOptions, transportScope(httpClientOptions, s))
+	if err != nil {
+		return nil, err
 	}
-	if httpClientOptions.EnableHTTP2 {
-		err := http2.ConfigureTransport(transport)
-		if err != nil {
-			return nil, err
-		}
+	transport, err := createTransport(httpClientOptions, s, d.DialContext)
+	if err != nil {
+		return nil, err
 	}
+	// SoloConn: every request on a fresh connection of its own, closed afterwards
+	transport.http1.DisableKeepAlives = httpClientOptions.DisableKeepAlives || httpClientOptions.SoloConn
+	transport.h2.DisableKeepAlives = transport.http1.DisableKeepAlives
 
-	if httpClientOptions.Proxy != "" {
-		proxy, err := url.Parse(httpClientOptions.Proxy)
-		if err != nil {
-			return nil, err
-		}
-		transport.Proxy = http.ProxyURL(proxy)
-	}
 	// default cookiejar
 	if jar == nil {
 		cookieJar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
@@ -322,20 +363,118 @@ func createHttpClient(httpClientOptions *ClientOptions, followRedirects bool, ja
 		Timeout:       time.Duration(httpClientOptions.ReadTimeout+httpClientOptions.DialTimeout) * time.Second,
 		Jar:           jar,
 		Transport:     transport,
-		CheckRedirect: makeCheckRedirectFunc(followRedirects, httpClientOptions.MaxRedirect),
+		CheckRedirect: makeCheckRedirectFunc(followRedirects, httpClientOptions, s),
 	}, nil
 }
 
-type checkRedirectFunc func(req *http.Request, via []*http.Request) error
+// createTransport transport of every protocol, dial opens the tcp connections
+// to the target or the proxy
+func createTransport(options *ClientOptions, s *scope, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (*protocolTransport, error) {
+	tlsClientConfig, err := xtls.NewTLSConfig(options.TlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	handshaker, err := xtls.NewHandshaker(options.TlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	dialTLS, err := xtls.NewTLSDialer(options.TlsOptions, dial)
+	if err != nil {
+		return nil, err
+	}
+	// enforced checks need the dialed host, net/http's own handshake only has the sni
+	if dialTLS == nil {
+		dialTLS = handshaker.DialTLS(dial, []string{"http/1.1"})
+	}
+
+	transport := &http.Transport{
+		DialContext:           dial,
+		DialTLSContext:        dialTLS,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		ResponseHeaderTimeout: time.Duration(options.ReadTimeout) * time.Second,
+		IdleConnTimeout:       time.Duration(options.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(options.TLSHandshakeTimeout) * time.Second,
+		MaxIdleConns:          options.MaxIdleConns,
+		TLSClientConfig:       tlsClientConfig,
+		DisableKeepAlives:     options.DisableKeepAlives,
+	}
+
+	if options.Proxy != "" {
+		proxy, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	// after the proxy is set, the derived h2 transport inherits it
+	return newProtocolTransport(options, transport, s, handshaker)
+}
+
+// dialURL connection to the host of u with the client's dial timeout, source
+// address, scope, proxy and tls settings, https urls are handshaked offering nextProtos
+func (c *Client) dialURL(ctx context.Context, u *url.URL, nextProtos []string) (net.Conn, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return c.dialTarget(ctx, u.Hostname(), port, u.Scheme == "https", nextProtos)
+}
 
-func makeCheckRedirectFunc(followRedirects bool, maxRedirects int) checkRedirectFunc {
-	return func(req *http.Request, via []*http.Request) error {
-		if !followRedirects {
-			return http.ErrUseLastResponse
+// handshakeTLS tls client handshake over conn with the client's tls settings
+// and ClientHello, client certificates are selected and enforced checks are
+// bound to serverName, conn is closed when it fails
+func (c *Client) handshakeTLS(ctx context.Context, conn net.Conn, serverName string, nextProtos []string) (net.Conn, error) {
+	handshaker := c.handshaker
+	if handshaker == nil {
+		var err error
+		if handshaker, err = xtls.NewHandshaker(xtls.DefaultClientOptions()); err != nil {
+			conn.Close()
+			return nil, err
 		}
-		if len(via) >= maxRedirects {
-			return http.ErrUseLastResponse
+	}
+	ctx = xtls.ContextWithServerName(ctx, func() string { return serverName })
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	tlsConn, err := handshaker.Handshake(ctx, conn, serverName, nextProtos)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		var state tls.ConnectionState
+		if err == nil {
+			state = *connState(tlsConn)
 		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	return tlsConn, err
+}
+
+// connState tls state of conn, crypto/tls or utls, nil for plain connections
+func connState(conn net.Conn) *tls.ConnectionState {
+	if tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := tlsConn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+// verifyTLS checks of the connection response came on, host is the target.
+// Enforced checks already ran in the handshake with the dialed host, except
+// for ip targets behind a proxy when net/http handshaked them: without sni the
+// host is unknown there, they are checked here and fail the request.
+func (c *Client) verifyTLS(response *Response, host string) error {
+	if c.tlsVerifier == nil || response.tlsInfo == nil {
+		return nil
+	}
+	if c.tlsVerifier.ReportOnly() {
+		response.tlsVerify = c.tlsVerifier.Verify(host, response.tlsInfo.State)
+		return nil
+	}
+	if c.ClientOptions.Proxy == "" || net.ParseIP(host) == nil || response.Request.direct || response.GetProtocol() == ProtocolH3 {
 		return nil
 	}
+	response.tlsVerify = c.tlsVerifier.Verify(host, response.tlsInfo.State)
+	return response.tlsVerify.Err
 }
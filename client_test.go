@@ -3,6 +3,7 @@ package shttp
 import (
 	"context"
 	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/iami317/shttp/xtls"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/publicsuffix"
 	"net/http"
@@ -177,3 +178,28 @@ func TestTransportCookie(t *testing.T) {
 	u, _ := url.Parse(ts.URL + "/transport-cookie")
 	require.Equal(t, "success5", cookieJar.Cookies(u)[0].Value, "could not transport cookie to multi client")
 }
+
+func TestClient_ClientHelloProfile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.TlsOptions.ClientHello = xtls.ClientHelloChrome
+	for _, solo := range []bool{false, true} {
+		options.SoloConn = solo
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+		hr, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, 200, resp.GetStatus())
+		info := resp.GetTLSInfo()
+		require.NotNil(t, info, "tls state of the utls connection")
+		require.Equal(t, "TLS 1.3", info.Version)
+		require.Len(t, info.Certificates, 1)
+	}
+
+	options.TlsOptions.ClientHello = "netscape"
+	_, err := NewClient(options, nil)
+	require.NotNil(t, err)
+}
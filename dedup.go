@@ -0,0 +1,237 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/thoas/go-funk"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupOptions coalesce identical in-flight requests and optionally cache the responses
+type DedupOptions struct {
+	Methods         []string `json:"methods" yaml:"methods" #:"参与合并的幂等请求方法, 默认 GET/HEAD"`
+	Headers         []string `json:"headers" yaml:"headers" #:"参与计算请求指纹的 header"`
+	CacheTTL        int      `json:"cache_ttl" yaml:"cache_ttl" #:"响应缓存时间(秒), 0 则只合并进行中的请求不缓存"`
+	CacheMaxEntries int      `json:"cache_max_entries" yaml:"cache_max_entries" #:"最大缓存的响应数, 0 不限制"`
+}
+
+// DefaultDedupOptions default dedup options, coalescing only
+func DefaultDedupOptions() *DedupOptions {
+	return &DedupOptions{
+		Methods: []string{MethodGet, MethodHead},
+		Headers: []string{"Authorization", "Cookie", "Range"},
+	}
+}
+
+// Clone dedup options
+func (o *DedupOptions) Clone() *DedupOptions {
+	newOptions := *o
+	newOptions.Methods = append([]string(nil), o.Methods...)
+	newOptions.Headers = append([]string(nil), o.Headers...)
+	return &newOptions
+}
+
+type dedupCall struct {
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+type dedupEntry struct {
+	resp    *Response
+	expires time.Time
+}
+
+// dedupGroup singleflight keyed by request fingerprint plus a ttl cache
+type dedupGroup struct {
+	options *DedupOptions
+
+	mu    sync.Mutex
+	calls map[string]*dedupCall
+	cache map[string]*dedupEntry
+}
+
+func newDedupGroup(options *DedupOptions) *dedupGroup {
+	return &dedupGroup{
+		options: options,
+		calls:   make(map[string]*dedupCall),
+		cache:   make(map[string]*dedupEntry),
+	}
+}
+
+// do runs fn once per fingerprint, every caller gets its own clone of the response
+func (g *dedupGroup) do(ctx context.Context, req *Request, fn func(context.Context, *Request) (*Response, error)) (*Response, error) {
+	if !funk.ContainsString(g.options.Methods, req.GetMethod()) {
+		return fn(ctx, req)
+	}
+	key, err := g.fingerprint(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		g.mu.Lock()
+		if entry, ok := g.cache[key]; ok {
+			if time.Now().Before(entry.expires) {
+				g.mu.Unlock()
+				return entry.resp.clone(req), nil
+			}
+			delete(g.cache, key)
+		}
+		call, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// the leader gave up on its own context, ours is still live: run it again
+		if isContextErr(call.err) && ctx.Err() == nil {
+			continue
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return call.resp.clone(req), nil
+	}
+	// g.mu is still held and nothing is in flight, this caller leads
+	call := &dedupCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	resp, err := fn(ctx, req)
+	if err == nil {
+		// keep a pristine copy, the caller is free to mutate resp
+		call.resp = resp.clone(req)
+	}
+	call.err = err
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	if err == nil && g.options.CacheTTL > 0 {
+		g.store(key, call.resp)
+	}
+	g.mu.Unlock()
+	close(call.done)
+	return resp, err
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// store must be called with g.mu held
+func (g *dedupGroup) store(key string, resp *Response) {
+	now := time.Now()
+	if g.options.CacheMaxEntries > 0 && len(g.cache) >= g.options.CacheMaxEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, entry := range g.cache {
+			if !now.Before(entry.expires) {
+				delete(g.cache, k)
+				continue
+			}
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = k, entry.expires
+			}
+		}
+		if len(g.cache) >= g.options.CacheMaxEntries {
+			delete(g.cache, oldestKey)
+		}
+	}
+	g.cache[key] = &dedupEntry{
+		resp:    resp,
+		expires: now.Add(time.Duration(g.options.CacheTTL) * time.Second),
+	}
+}
+
+// fingerprint method, normalized url, selected headers and body hash
+func (g *dedupGroup) fingerprint(req *Request) (string, error) {
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(req.GetMethod())
+	b.WriteByte('\n')
+	b.WriteString(normalizeURL(req.GetUrl()))
+	b.WriteByte('\n')
+	b.WriteString(req.RawRequest.Host)
+	b.WriteByte('\n')
+	for _, h := range g.options.Headers {
+		b.WriteString(http.CanonicalHeaderKey(h))
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.GetHeaders().Values(h), ","))
+		b.WriteByte('\n')
+	}
+	sum := sha256.Sum256(body)
+	b.WriteString(hex.EncodeToString(sum[:]))
+	return b.String(), nil
+}
+
+// normalizeURL lower case scheme/host, strip default port and fragment, sort query
+func normalizeURL(u *url.URL) string {
+	nu := *u
+	nu.Scheme = strings.ToLower(nu.Scheme)
+	host := strings.ToLower(nu.Hostname())
+	port := nu.Port()
+	if (nu.Scheme == "http" && port == "80") || (nu.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	nu.Host = host
+	nu.Fragment = ""
+	nu.RawFragment = ""
+	if nu.Path == "" {
+		nu.Path = "/"
+	}
+	if nu.RawQuery != "" {
+		nu.RawQuery = nu.Query().Encode()
+	}
+	return nu.String()
+}
+
+// clone deep copies the response for another caller, the body is already read
+func (r *Response) clone(req *Request) *Response {
+	newResp := &Response{
+		Request:     req,
+		Body:        append([]byte(nil), r.Body...),
+		receivedAt:  r.receivedAt,
+		cacheStatus: r.cacheStatus,
+		redirects:   r.redirects,
+		tlsVerify:   r.tlsVerify,
+		tlsInfo:     r.tlsInfo,
+		wire:        append([]byte(nil), r.wire...),
+		anomalies:   append([]string(nil), r.anomalies...),
+	}
+	if req != r.Request {
+		req.sendAt = r.Request.sendAt
+		req.attempt = r.Request.attempt
+		req.localAddr = r.Request.localAddr
+		req.remoteAddr = r.Request.remoteAddr
+	}
+	if r.RawResponse != nil {
+		rawResp := *r.RawResponse
+		rawResp.Header = r.RawResponse.Header.Clone()
+		rawResp.Trailer = r.RawResponse.Trailer.Clone()
+		rawResp.Body = io.NopCloser(bytes.NewReader(newResp.Body))
+		newResp.RawResponse = &rawResp
+	}
+	return newResp
+}
@@ -0,0 +1,131 @@
+package shttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDedup_Coalesce(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("dedup"))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Dedup = DefaultDedupOptions()
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	var wg sync.WaitGroup
+	responses := make([]*Response, 10)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// query order and fragment don't matter
+			hr, _ := http.NewRequest("GET", ts.URL+"/?b=2&a=1#x", nil)
+			if i%2 == 0 {
+				hr, _ = http.NewRequest("GET", ts.URL+"/?a=1&b=2", nil)
+			}
+			resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+			require.Nil(t, err)
+			responses[i] = resp
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	responses[0].Body[0] = 'X'
+	responses[0].GetHeaders().Set("X-Mutated", "1")
+	for _, resp := range responses[1:] {
+		require.Equal(t, "dedup", string(resp.GetBody()))
+		require.Empty(t, resp.GetHeaders().Get("X-Mutated"))
+	}
+
+	// no cache configured, a later request goes to the server again
+	hr, _ := http.NewRequest("GET", ts.URL+"/?a=1&b=2", nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestDedup_LeaderCanceled(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("dedup"))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Dedup = DefaultDedupOptions()
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	leaderDone := make(chan error, 1)
+	go func() {
+		hr, _ := http.NewRequest("GET", ts.URL, nil)
+		_, err := client.Do(ctx, &Request{RawRequest: hr})
+		leaderDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the waiter's own context is live, it takes over instead of failing with the leader
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, "dedup", string(resp.GetBody()))
+	require.NotNil(t, <-leaderDone)
+	require.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestDedup_Cache(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(r.Method))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Dedup = DefaultDedupOptions()
+	options.Dedup.CacheTTL = 60
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		hr, _ := http.NewRequest("GET", ts.URL+"/cache", nil)
+		resp, err := client.Do(ctx, &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, "GET", string(resp.GetBody()))
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	// different header value is a different request
+	hr, _ := http.NewRequest("GET", ts.URL+"/cache", nil)
+	hr.Header.Set("Authorization", "Basic YTpi")
+	_, err = client.Do(ctx, &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&hits))
+
+	// non idempotent methods are never coalesced
+	for i := 0; i < 2; i++ {
+		hr, _ := http.NewRequest("POST", ts.URL+"/cache", nil)
+		_, err = client.Do(ctx, &Request{RawRequest: hr})
+		require.Nil(t, err)
+	}
+	require.Equal(t, int32(4), atomic.LoadInt32(&hits))
+}
@@ -0,0 +1,336 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source address rotations, see ClientOptions.SourceRotation
+const (
+	SourceRoundRobin = "round-robin"
+	SourceRandom     = "random"
+	SourcePerHost    = "per-host" // the same target always leaves from the same address
+)
+
+// IP families, see ClientOptions.IPFamily
+const (
+	IPv4       = "ipv4"
+	IPv6       = "ipv6"
+	PreferIPv4 = "prefer-ipv4" // every ipv4 address is tried before the ipv6 ones
+	PreferIPv6 = "prefer-ipv6"
+)
+
+func verifyIPFamily(family string) error {
+	switch family {
+	case "", IPv4, IPv6, PreferIPv4, PreferIPv6:
+		return nil
+	}
+	return fmt.Errorf("unknown ip family %s", family)
+}
+
+// DialFunc opens the connection to addr, a target or the proxy
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialer tcp dialer of the client, picks the address family and binds the
+// source address from the pool. A unix socket or a custom dial function replace
+// it altogether.
+type dialer struct {
+	net.Dialer
+	family     string
+	sources    *sourcePool
+	unixSocket string
+	custom     DialFunc
+}
+
+// newDialer dial timeout, source address binding and the scope check of the
+// resolved address, s is nil when the dial goes to a proxy
+func newDialer(options *ClientOptions, s *scope) (*dialer, error) {
+	if err := verifyIPFamily(options.IPFamily); err != nil {
+		return nil, err
+	}
+	if options.UnixSocket != "" && options.DialContext != nil {
+		return nil, errors.New("unix_socket and dial_context are exclusive")
+	}
+	sources, err := newSourcePool(options)
+	if err != nil {
+		return nil, err
+	}
+	return &dialer{
+		Dialer: net.Dialer{
+			Timeout: time.Duration(options.DialTimeout) * time.Second,
+			Control: s.control(),
+		},
+		family:     options.IPFamily,
+		sources:    sources,
+		unixSocket: options.UnixSocket,
+		custom:     options.DialContext,
+	}, nil
+}
+
+// transportScope 走代理时连接的是代理地址, 无法在 dial 阶段检查目标 ip
+func transportScope(options *ClientOptions, s *scope) *scope {
+	if options.Proxy != "" {
+		return nil
+	}
+	return s
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.custom != nil {
+		return d.custom(ctx, network, addr)
+	}
+	if d.unixSocket != "" {
+		// the scope checks ip addresses, a local socket has none
+		nd := net.Dialer{Timeout: d.Timeout}
+		return nd.DialContext(ctx, "unix", d.unixSocket)
+	}
+	switch d.family {
+	case IPv4:
+		return d.dialSource(ctx, "tcp4", addr)
+	case IPv6:
+		return d.dialSource(ctx, "tcp6", addr)
+	case PreferIPv4, PreferIPv6:
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := resolveHost(ctx, host, d.family)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = d.dialSource(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+	return d.dialSource(ctx, network, addr)
+}
+
+func (d *dialer) dialSource(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.sources == nil {
+		return d.Dialer.DialContext(ctx, network, addr)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	var err error
+	for _, ip := range d.sources.candidates(host) {
+		nd := d.Dialer
+		nd.LocalAddr = &net.TCPAddr{IP: ip}
+		var conn net.Conn
+		if conn, err = nd.DialContext(ctx, network, addr); err == nil {
+			return conn, nil
+		}
+		// the target has no address of this source's family, try the other family
+		var addrErr *net.AddrError
+		if !errors.As(err, &addrErr) || addrErr.Err != "no suitable address found" {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// resolveHost addresses of host in the order the family asks for, only the
+// addresses of the family when it is forced
+func resolveHost(ctx context.Context, host, family string) ([]net.IP, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch family {
+	case IPv4:
+		ips = v4
+	case IPv6:
+		ips = v6
+	case PreferIPv4:
+		ips = append(v4, v6...)
+	case PreferIPv6:
+		ips = append(v6, v4...)
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no " + family + " address", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// sourcePool local addresses outbound connections are bound to
+type sourcePool struct {
+	ips      []net.IP
+	rotation string
+	next     atomic.Uint64
+}
+
+// newSourcePool nil when neither source ips nor an interface are configured
+func newSourcePool(options *ClientOptions) (*sourcePool, error) {
+	if len(options.SourceIPs) == 0 && options.Interface == "" {
+		return nil, nil
+	}
+	if len(options.SourceIPs) > 0 && options.Interface != "" {
+		return nil, errors.New("source_ips and interface are exclusive")
+	}
+	switch options.SourceRotation {
+	case "", SourceRoundRobin, SourceRandom, SourcePerHost:
+	default:
+		return nil, fmt.Errorf("unknown source rotation %s", options.SourceRotation)
+	}
+	p := &sourcePool{rotation: options.SourceRotation}
+	for _, s := range options.SourceIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source ip %s", s)
+		}
+		p.ips = append(p.ips, ip)
+	}
+	if options.Interface != "" {
+		iface, err := net.InterfaceByName(options.Interface)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			// link local v6 addresses need a zone and can't reach targets anyway
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			p.ips = append(p.ips, ipNet.IP)
+		}
+		if len(p.ips) == 0 {
+			return nil, fmt.Errorf("interface %s has no usable address", options.Interface)
+		}
+	}
+	return p, nil
+}
+
+// candidates every address, starting with the one chosen by the rotation
+func (p *sourcePool) candidates(host string) []net.IP {
+	var start int
+	switch p.rotation {
+	case SourceRandom:
+		start = rand.Intn(len(p.ips))
+	case SourcePerHost:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(host))
+		start = int(h.Sum32() % uint32(len(p.ips)))
+	default:
+		start = int((p.next.Add(1) - 1) % uint64(len(p.ips)))
+	}
+	return append(append([]net.IP(nil), p.ips[start:]...), p.ips[:start]...)
+}
+
+// pick source address of family of ip for a udp socket, nil when the pool has none
+func (p *sourcePool) pick(host string, ip net.IP) net.IP {
+	if p == nil {
+		return nil
+	}
+	v4 := ip.To4() != nil
+	for _, source := range p.candidates(host) {
+		if (source.To4() != nil) == v4 {
+			return source
+		}
+	}
+	return nil
+}
+
+// AddrResult response of one resolved address, Err is set instead of Response
+// when the request failed
+type AddrResult struct {
+	IP       net.IP
+	Response *Response
+	Err      error
+}
+
+// DoAllAddrs sends req to every address its host resolves to (limited by
+// IPFamily), each over a connection of its own, to spot backends behind one name
+// that behave differently. Results follow the resolution order, the address a
+// response came from is also in Response.GetRemoteAddr. Redirects go to the
+// original address only when they stay on the same host.
+func (c *Client) DoAllAddrs(ctx context.Context, req *Request) ([]*AddrResult, error) {
+	if c.ClientOptions.Proxy != "" {
+		return nil, errors.New("all addrs: the proxy resolves the host, can't pick an address")
+	}
+	if c.dialer.unixSocket != "" || c.dialer.custom != nil {
+		return nil, errors.New("all addrs: connections come from the unix socket or the custom dial function")
+	}
+	host := req.RawRequest.URL.Hostname()
+	ips, err := resolveHost(ctx, host, c.ClientOptions.IPFamily)
+	if err != nil {
+		return nil, err
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*AddrResult, len(ips))
+	var wg sync.WaitGroup
+	wg.Add(len(ips))
+	for i, ip := range ips {
+		results[i] = &AddrResult{IP: ip}
+		go func(result *AddrResult) {
+			defer wg.Done()
+			clone := req.Clone()
+			if len(body) > 0 && clone.chunked == nil {
+				clone.SetBody(body)
+			}
+			result.Response, result.Err = c.doAddr(ctx, clone, host, result.IP)
+		}(results[i])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// doAddr Client.Do with connections to host pinned to ip
+func (c *Client) doAddr(ctx context.Context, req *Request, host string, ip net.IP) (*Response, error) {
+	nc := c.tryBestClone()
+	// quic resolves on its own
+	nc.ClientOptions.AltSvc = false
+	// every address must really be asked, a shared cache storage or a
+	// coalesced request would answer for all of them
+	nc.cache = nil
+	nc.dedup = nil
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if h, port, err := net.SplitHostPort(addr); err == nil && h == host {
+			addr = net.JoinHostPort(ip.String(), port)
+		}
+		return c.dialer.dialSource(ctx, network, addr)
+	}
+	// requests with a chunked body dial on their own
+	nc.dialer = &dialer{custom: dial}
+	transport, err := createTransport(nc.ClientOptions, c.scope, dial)
+	if err != nil {
+		return nil, err
+	}
+	transport.http1.DisableKeepAlives = true
+	transport.h2.DisableKeepAlives = true
+	nc.HTTPClient.Transport = transport
+	defer nc.HTTPClient.CloseIdleConnections()
+	return nc.Do(ctx, req)
+}
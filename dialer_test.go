@@ -0,0 +1,243 @@
+package shttp
+
+import (
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_SourceIPs(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		mu.Lock()
+		seen = append(seen, host)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	do := func(client *Client) *Response {
+		hr, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		return resp
+	}
+
+	options := DefaultClientOptions()
+	options.SoloConn = true
+	options.SourceIPs = []string{"127.0.0.2", "127.0.0.3"}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for i := 0; i < 4; i++ {
+		resp := do(client)
+		require.Equal(t, seen[i], resp.GetLocalAddr().(*net.TCPAddr).IP.String())
+	}
+	require.Equal(t, []string{"127.0.0.2", "127.0.0.3", "127.0.0.2", "127.0.0.3"}, seen)
+
+	// a target sticks to its source address
+	seen = nil
+	options.SourceRotation = SourcePerHost
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		do(client)
+	}
+	require.Equal(t, seen[0], seen[1])
+	require.Equal(t, seen[0], seen[2])
+
+	// the pool of the interface, the v6 address can't reach a v4 target
+	options.SourceIPs = nil
+	options.Interface = "lo"
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	require.True(t, do(client).GetLocalAddr().(*net.TCPAddr).IP.IsLoopback())
+
+	options.SourceIPs = []string{"127.0.0.2"}
+	_, err = NewClient(options, nil)
+	require.NotNil(t, err)
+	options.Interface = ""
+	options.SourceIPs = []string{"not-an-ip"}
+	_, err = NewClient(options, nil)
+	require.NotNil(t, err)
+}
+
+func TestClient_SourceIPsH3(t *testing.T) {
+	ts := testhttp.CreateH3Server(t)
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Protocol = ProtocolH3
+	options.SourceIPs = []string{"127.0.0.2"}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, ProtocolH3, resp.GetProtocol())
+
+	options.SourceIPs = []string{"::1"}
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ = http.NewRequest("GET", ts.URL, nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.NotNil(t, err)
+}
+
+func TestClient_IPFamily(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	target := "http://localhost:" + port
+
+	do := func(family string) (*Response, error) {
+		options := DefaultClientOptions()
+		options.IPFamily = family
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+		hr, _ := http.NewRequest("GET", target, nil)
+		return client.Do(context.Background(), &Request{RawRequest: hr})
+	}
+	for _, family := range []string{IPv4, PreferIPv4, PreferIPv6} {
+		resp, err := do(family)
+		require.Nil(t, err, family)
+		require.Equal(t, "127.0.0.1", resp.GetRemoteAddr().(*net.TCPAddr).IP.String())
+	}
+	// the server only listens on ipv4
+	_, err := do(IPv6)
+	require.NotNil(t, err)
+	options := DefaultClientOptions()
+	options.IPFamily = "ipv5"
+	_, err = NewClient(options, nil)
+	require.NotNil(t, err)
+
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("POST", target, nil)
+	req := (&Request{RawRequest: hr}).SetBody([]byte("body"))
+	results, err := client.DoAllAddrs(context.Background(), req)
+	require.Nil(t, err)
+	require.NotEmpty(t, results)
+	for _, result := range results {
+		if result.IP.To4() == nil {
+			continue
+		}
+		require.Nil(t, result.Err)
+		require.Equal(t, result.IP.String(), result.Response.GetRemoteAddr().(*net.TCPAddr).IP.String())
+		// the host header keeps the name
+		require.Equal(t, "localhost:"+port, string(result.Response.GetBody()))
+	}
+}
+
+func TestClient_DoAllAddrsCache(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Cache = &CacheOptions{Dir: t.TempDir()}
+	options.Dedup = &DedupOptions{CacheTTL: 60, Methods: []string{http.MethodGet}}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+
+	results, err := client.DoAllAddrs(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.Nil(t, results[0].Err)
+	require.Empty(t, results[0].Response.GetCacheStatus())
+	require.Equal(t, int32(2), hits.Load())
+}
+
+func TestClient_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", path)
+	require.Nil(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.UnixSocket = path
+	for _, solo := range []bool{false, true} {
+		options.SoloConn = solo
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+		hr, _ := http.NewRequest("GET", "http://docker/v1.41/info", nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, "docker/v1.41/info", string(resp.GetBody()))
+	}
+
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	session, err := client.NewSession(nil)
+	require.Nil(t, err)
+	defer session.Close()
+	hr, _ := http.NewRequest("GET", "http://docker/_ping", nil)
+	resp, err := session.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, "docker/_ping", string(resp.GetBody()))
+	require.Equal(t, "unix", session.RemoteAddr().Network())
+
+	options.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, nil
+	}
+	_, err = NewClient(options, nil)
+	require.NotNil(t, err)
+}
+
+func TestClient_DialContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+
+	var (
+		mu    sync.Mutex
+		addrs []string
+	)
+	options := DefaultClientOptions()
+	options.SoloConn = true
+	options.Protocol = ProtocolH3
+	// a tunnel: every target is reached through the test server
+	options.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		addrs = append(addrs, addr)
+		mu.Unlock()
+		var d net.Dialer
+		return d.DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		hr, _ := http.NewRequest("GET", "http://internal.example:8080/", nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, "internal.example:8080", string(resp.GetBody()))
+	}
+	require.Equal(t, []string{"internal.example:8080", "internal.example:8080"}, addrs)
+
+	hr, _ := http.NewRequest("GET", "https://internal.example/", nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.ErrorIs(t, err, ErrH3Dialer)
+}
@@ -0,0 +1,196 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// doDirect one attempt of a chunked request written by hand on a connection of
+// its own, the session's in a session. The connection is closed with the
+// response body, a lenient response is read whole before returning
+func (c *Client) doDirect(req *Request) (*http.Response, error) {
+	ctx := req.GetContext()
+	u := req.RawRequest.URL
+	req.lenient = nil
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	rawReq := req.RawRequest.Clone(ctx)
+	// a lenient response without framing ends when the server closes
+	rawReq.Close = rawReq.Close || c.ClientOptions.LenientResponse
+	if c.HTTPClient.Jar != nil {
+		for _, cookie := range c.HTTPClient.Jar.Cookies(u) {
+			rawReq.AddCookie(cookie)
+		}
+	}
+	raw, err := writeRequest(ctx, rawReq, body, req.chunked)
+	if err != nil {
+		return nil, err
+	}
+	req.raw = raw
+
+	resp, err := c.roundTripDirect(req, req.RawRequest, raw)
+	if err != nil {
+		return nil, err
+	}
+	if c.HTTPClient.Jar != nil {
+		if cookies := resp.Cookies(); len(cookies) > 0 {
+			c.HTTPClient.Jar.SetCookies(u, cookies)
+		}
+	}
+	return resp, nil
+}
+
+// roundTripDirect writes raw to the host of hr on a connection of its own and
+// reads the response, leniently when LenientResponse is on. In a session the
+// request takes its connection over
+func (c *Client) roundTripDirect(req *Request, hr *http.Request, raw []byte) (*http.Response, error) {
+	ctx := hr.Context()
+	u := hr.URL
+	lenient := c.ClientOptions.LenientResponse
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.GetConn != nil {
+		trace.GetConn(net.JoinHostPort(u.Hostname(), port))
+	}
+	conn, err := c.dialTargetVia(ctx, c.sessionDial, u.Hostname(), port, u.Scheme == "https", []string{"http/1.1"})
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+	}
+	if c.HTTPClient.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.HTTPClient.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	if _, err = conn.Write(raw); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	var r io.Reader = conn
+	if trace != nil {
+		if trace.WroteRequest != nil {
+			trace.WroteRequest(httptrace.WroteRequestInfo{})
+		}
+		if trace.GotFirstResponseByte != nil {
+			r = &firstByteReader{Reader: conn, fn: trace.GotFirstResponseByte}
+		}
+	}
+	var resp *http.Response
+	if lenient {
+		var result *lenientResponse
+		resp, result, err = readLenientResponse(ctx, r, hr, c.ClientOptions.MaxRespBodySize)
+		req.lenient = result
+	} else {
+		resp, err = http.ReadResponse(bufio.NewReader(r), hr)
+	}
+	if err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	resp.TLS = connState(conn)
+	req.localAddr, req.remoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	req.direct = true
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+// lenientTransport http/1.1 round trips of a LenientResponse client written on
+// connections of their own, http.Client still follows redirects and keeps the
+// cookies. Requests going over another protocol are sent by next
+type lenientTransport struct {
+	client *Client
+	req    *Request
+	next   http.RoundTripper
+}
+
+func (t *lenientTransport) RoundTrip(hr *http.Request) (*http.Response, error) {
+	t.req.lenient, t.req.direct = nil, false
+	if !t.client.lenientProtocol(hr) {
+		if t.next == nil {
+			return http.DefaultTransport.RoundTrip(hr)
+		}
+		return t.next.RoundTrip(hr)
+	}
+	var body []byte
+	if hr.Body != nil {
+		var err error
+		body, err = io.ReadAll(hr.Body)
+		hr.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rawReq := hr.Clone(hr.Context())
+	// a lenient response without framing ends when the server closes
+	rawReq.Close = true
+	raw, err := writeRequest(hr.Context(), rawReq, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.req.raw = raw
+	return t.client.roundTripDirect(t.req, hr, raw)
+}
+
+// lenientProtocol whether hr goes over http/1.1, the only protocol read leniently
+func (c *Client) lenientProtocol(hr *http.Request) bool {
+	protocol := c.ClientOptions.Protocol
+	if protocol == "" && c.ClientOptions.EnableHTTP2 {
+		protocol = ProtocolH2
+	}
+	if p, ok := hr.Context().Value(protocolContextKey{}).(string); ok && p != "" {
+		protocol = p
+	}
+	switch protocol {
+	case "", ProtocolHTTP1:
+		return true
+	case ProtocolH2, ProtocolH3:
+		// plain http stays on http/1.1
+		return hr.URL.Scheme == "http"
+	}
+	return false
+}
+
+// connBody response body that closes the connection it is read from
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connBody) Close() error {
+	b.stop()
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
+}
+
+// firstByteReader calls fn once the first byte was read
+type firstByteReader struct {
+	io.Reader
+	fn func()
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.fn != nil {
+		r.fn()
+		r.fn = nil
+	}
+	return n, err
+}
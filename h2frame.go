@@ -0,0 +1,510 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// H2Header one header field, sent as is: pseudo headers may be repeated,
+// reordered or invented and names are not validated
+type H2Header struct {
+	Name  string
+	Value string
+}
+
+// H2ConnOptions connection setup, the zero value sends the preface and an empty SETTINGS
+type H2ConnOptions struct {
+	Settings        []http2.Setting // sent in the first SETTINGS frame
+	SkipPreface     bool            // don't send the client preface
+	SkipSettings    bool            // don't send the first SETTINGS frame
+	NoAutoAck       bool            // don't answer SETTINGS and PING of the server
+	HeaderTableSize uint32          // hpack decoder table size, default 4096
+	// MaxHeaderListSize bytes of a received header block with its
+	// CONTINUATION frames, default 1MB
+	MaxHeaderListSize uint32
+	MaxContinuations  int // CONTINUATION frames of a received header block, default 1000
+}
+
+// ErrH2HeaderBlockTooLarge the server kept a header block going past
+// MaxHeaderListSize or MaxContinuations
+var ErrH2HeaderBlockTooLarge = errors.New("h2: header block too large")
+
+// H2Frame frame received from the server, header blocks are already merged
+// with their CONTINUATION frames and decoded
+type H2Frame struct {
+	Type     http2.FrameType
+	Flags    http2.Flags
+	StreamID uint32
+	Length   uint32
+
+	Headers       []H2Header      // HEADERS, PUSH_PROMISE
+	Continuations int             // CONTINUATION frames merged into Headers
+	Data          []byte          // DATA payload, opaque data of GOAWAY, unknown frame payload
+	ErrCode       http2.ErrCode   // RST_STREAM, GOAWAY
+	LastStreamID  uint32          // GOAWAY
+	Settings      []http2.Setting // SETTINGS
+	Increment     uint32          // WINDOW_UPDATE
+	PromiseID     uint32          // PUSH_PROMISE
+	ReceivedAt    time.Time
+}
+
+// H2StreamResponse frames of one stream until it ends or is reset
+type H2StreamResponse struct {
+	StreamID uint32
+	Headers  []H2Header
+	Trailers []H2Header
+	Body     []byte
+	Reset    bool
+	ErrCode  http2.ErrCode // of the RST_STREAM or GOAWAY that ended the stream
+	Frames   []*H2Frame    // frames of the stream and of the connection read while waiting
+	// ReceivedAt when the response headers arrived
+	ReceivedAt time.Time
+}
+
+// Status :status of the response headers
+func (r *H2StreamResponse) Status() string {
+	for _, h := range r.Headers {
+		if h.Name == ":status" {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// H2Conn frame level http/2 connection for protocol testing, writes are
+// serialized, reads must come from a single goroutine
+type H2Conn struct {
+	conn    net.Conn
+	framer  *http2.Framer
+	options *H2ConnOptions
+
+	wmu          sync.Mutex
+	encoder      *hpack.Encoder
+	encBuf       bytes.Buffer
+	decoder      *hpack.Decoder
+	nextStreamID uint32
+	maxFrameSize uint32
+	// bounds of a received header block
+	maxHeaderListSize int
+	maxContinuations  int
+}
+
+// NewH2Conn speaks http/2 on an established connection, the preface and
+// SETTINGS are sent according to options
+func NewH2Conn(conn net.Conn, options *H2ConnOptions) (*H2Conn, error) {
+	if options == nil {
+		options = &H2ConnOptions{}
+	}
+	tableSize := options.HeaderTableSize
+	if tableSize == 0 {
+		tableSize = 4096
+	}
+	c := &H2Conn{
+		conn:              conn,
+		framer:            http2.NewFramer(conn, conn),
+		options:           options,
+		decoder:           hpack.NewDecoder(tableSize, nil),
+		nextStreamID:      1,
+		maxFrameSize:      16384,
+		maxHeaderListSize: 1 << 20,
+		maxContinuations:  1000,
+	}
+	if options.MaxHeaderListSize > 0 {
+		c.maxHeaderListSize = int(options.MaxHeaderListSize)
+	}
+	if options.MaxContinuations > 0 {
+		c.maxContinuations = options.MaxContinuations
+	}
+	c.encoder = hpack.NewEncoder(&c.encBuf)
+	// frames are only parsed here, validation is up to the caller
+	c.framer.AllowIllegalReads = true
+	c.framer.AllowIllegalWrites = true
+	c.decoder.SetEmitEnabled(true)
+
+	if !options.SkipPreface {
+		if _, err := io.WriteString(conn, http2ClientPreface); err != nil {
+			return nil, err
+		}
+	}
+	if !options.SkipSettings {
+		if err := c.WriteSettings(options.Settings...); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// DialH2 connects to an http or https url with the client's dial timeout,
+// scope and tls settings, https negotiates h2 by alpn, http uses prior knowledge
+func (c *Client) DialH2(ctx context.Context, target string, options *H2ConnOptions) (*H2Conn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.scope.checkURL(u); err != nil {
+		return nil, err
+	}
+	conn, err := c.dialURL(ctx, u, []string{http2.NextProtoTLS})
+	if err != nil {
+		return nil, err
+	}
+	if state := connState(conn); state != nil {
+		if proto := state.NegotiatedProtocol; proto != http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("h2: server negotiated %q", proto)
+		}
+	}
+	h2Conn, err := NewH2Conn(conn, options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return h2Conn, nil
+}
+
+// Conn underlying connection
+func (c *H2Conn) Conn() net.Conn {
+	return c.conn
+}
+
+// Framer for frames the helpers below don't cover, guard writes with care
+// since the helpers may write concurrently
+func (c *H2Conn) Framer() *http2.Framer {
+	return c.framer
+}
+
+// NextStreamID reserves the next odd client stream id
+func (c *H2Conn) NextStreamID() uint32 {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	id := c.nextStreamID
+	c.nextStreamID += 2
+	return id
+}
+
+// SetMaxFrameSize header blocks larger than size are split into CONTINUATION frames
+func (c *H2Conn) SetMaxFrameSize(size uint32) {
+	c.wmu.Lock()
+	c.maxFrameSize = size
+	c.wmu.Unlock()
+}
+
+// EncodeHeaders hpack encodes headers without any validation, the encoder's
+// dynamic table is shared with WriteHeaders
+func (c *H2Conn) EncodeHeaders(headers []H2Header) []byte {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.encodeHeaders(headers)
+}
+
+func (c *H2Conn) encodeHeaders(headers []H2Header) []byte {
+	c.encBuf.Reset()
+	for _, h := range headers {
+		_ = c.encoder.WriteField(hpack.HeaderField{Name: h.Name, Value: h.Value})
+	}
+	return append([]byte(nil), c.encBuf.Bytes()...)
+}
+
+// WriteHeaders sends a HEADERS frame followed by CONTINUATION frames when the
+// block exceeds the max frame size
+func (c *H2Conn) WriteHeaders(streamID uint32, headers []H2Header, endStream bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	block := c.encodeHeaders(headers)
+	first := block
+	if uint32(len(first)) > c.maxFrameSize {
+		first = block[:c.maxFrameSize]
+	}
+	block = block[len(first):]
+	err := c.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: first,
+		EndStream:     endStream,
+		EndHeaders:    len(block) == 0,
+	})
+	for err == nil && len(block) > 0 {
+		fragment := block
+		if uint32(len(fragment)) > c.maxFrameSize {
+			fragment = block[:c.maxFrameSize]
+		}
+		block = block[len(fragment):]
+		err = c.framer.WriteContinuation(streamID, len(block) == 0, fragment)
+	}
+	return err
+}
+
+// WriteHeaderBlock sends a HEADERS frame with a raw block fragment, the rest
+// of the block (if any) is sent with WriteContinuation
+func (c *H2Conn) WriteHeaderBlock(streamID uint32, fragment []byte, endStream, endHeaders bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: fragment,
+		EndStream:     endStream,
+		EndHeaders:    endHeaders,
+	})
+}
+
+func (c *H2Conn) WriteContinuation(streamID uint32, fragment []byte, endHeaders bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteContinuation(streamID, endHeaders, fragment)
+}
+
+func (c *H2Conn) WriteData(streamID uint32, data []byte, endStream bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteData(streamID, endStream, data)
+}
+
+func (c *H2Conn) WriteSettings(settings ...http2.Setting) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteSettings(settings...)
+}
+
+func (c *H2Conn) WriteSettingsAck() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteSettingsAck()
+}
+
+func (c *H2Conn) WriteRSTStream(streamID uint32, code http2.ErrCode) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteRSTStream(streamID, code)
+}
+
+func (c *H2Conn) WriteWindowUpdate(streamID, increment uint32) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteWindowUpdate(streamID, increment)
+}
+
+func (c *H2Conn) WritePriority(streamID uint32, priority http2.PriorityParam) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WritePriority(streamID, priority)
+}
+
+func (c *H2Conn) WritePing(ack bool, data [8]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WritePing(ack, data)
+}
+
+func (c *H2Conn) WriteGoAway(lastStreamID uint32, code http2.ErrCode, debugData []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteGoAway(lastStreamID, code, debugData)
+}
+
+// WriteBatch frames written by fn are buffered and sent with a single write,
+// small batches leave in one tcp packet
+func (c *H2Conn) WriteBatch(fn func(framer *http2.Framer) error) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var buf bytes.Buffer
+	framer := http2.NewFramer(&buf, nil)
+	framer.AllowIllegalWrites = true
+	if err := fn(framer); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// WriteRawFrame any frame type, flags and payload
+func (c *H2Conn) WriteRawFrame(t http2.FrameType, flags http2.Flags, streamID uint32, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.framer.WriteRawFrame(t, flags, streamID, payload)
+}
+
+// ReadFrame next frame from the server, SETTINGS and PING are acknowledged
+// unless NoAutoAck, a zero timeout waits forever
+func (c *H2Conn) ReadFrame(timeout time.Duration) (*H2Frame, error) {
+	if timeout > 0 {
+		_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.conn.SetReadDeadline(time.Time{})
+	}
+	frame, err := c.framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	header := frame.Header()
+	f := &H2Frame{
+		Type:       header.Type,
+		Flags:      header.Flags,
+		StreamID:   header.StreamID,
+		Length:     header.Length,
+		ReceivedAt: time.Now(),
+	}
+	switch fr := frame.(type) {
+	case *http2.HeadersFrame:
+		err = c.readHeaderBlock(f, fr.HeaderBlockFragment(), fr.HeadersEnded())
+	case *http2.PushPromiseFrame:
+		f.PromiseID = fr.PromiseID
+		err = c.readHeaderBlock(f, fr.HeaderBlockFragment(), fr.HeadersEnded())
+	case *http2.DataFrame:
+		f.Data = append([]byte(nil), fr.Data()...)
+	case *http2.RSTStreamFrame:
+		f.ErrCode = fr.ErrCode
+	case *http2.GoAwayFrame:
+		f.ErrCode = fr.ErrCode
+		f.LastStreamID = fr.LastStreamID
+		f.Data = append([]byte(nil), fr.DebugData()...)
+	case *http2.SettingsFrame:
+		_ = fr.ForeachSetting(func(s http2.Setting) error {
+			f.Settings = append(f.Settings, s)
+			return nil
+		})
+		if !fr.IsAck() && !c.options.NoAutoAck {
+			err = c.WriteSettingsAck()
+		}
+	case *http2.PingFrame:
+		f.Data = append([]byte(nil), fr.Data[:]...)
+		if !fr.IsAck() && !c.options.NoAutoAck {
+			err = c.WritePing(true, fr.Data)
+		}
+	case *http2.WindowUpdateFrame:
+		f.Increment = fr.Increment
+	case *http2.UnknownFrame:
+		f.Data = append([]byte(nil), fr.Payload()...)
+	}
+	return f, err
+}
+
+// readHeaderBlock reads the CONTINUATION frames of an unfinished block and decodes it
+func (c *H2Conn) readHeaderBlock(f *H2Frame, fragment []byte, ended bool) error {
+	if len(fragment) > c.maxHeaderListSize {
+		return ErrH2HeaderBlockTooLarge
+	}
+	block := append([]byte(nil), fragment...)
+	for !ended {
+		frame, err := c.framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+		cont, ok := frame.(*http2.ContinuationFrame)
+		if !ok || cont.StreamID != f.StreamID {
+			return fmt.Errorf("h2: expected CONTINUATION for stream %d, got %v", f.StreamID, frame.Header())
+		}
+		f.Continuations++
+		if f.Continuations > c.maxContinuations || len(block)+len(cont.HeaderBlockFragment()) > c.maxHeaderListSize {
+			return ErrH2HeaderBlockTooLarge
+		}
+		block = append(block, cont.HeaderBlockFragment()...)
+		ended = cont.HeadersEnded()
+	}
+	fields, err := c.decoder.DecodeFull(block)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		f.Headers = append(f.Headers, H2Header{Name: field.Name, Value: field.Value})
+	}
+	return nil
+}
+
+// ReadResponse reads frames until streamID ends, is reset or the connection is
+// going away, timeout bounds every single read
+func (c *H2Conn) ReadResponse(streamID uint32, timeout time.Duration) (*H2StreamResponse, error) {
+	resps, err := c.ReadResponses([]uint32{streamID}, timeout)
+	return resps[0], err
+}
+
+// ReadResponses reads frames until every stream of streamIDs ended, the
+// responses are in the order of streamIDs
+func (c *H2Conn) ReadResponses(streamIDs []uint32, timeout time.Duration) ([]*H2StreamResponse, error) {
+	resps := make([]*H2StreamResponse, len(streamIDs))
+	pending := make(map[uint32]*H2StreamResponse, len(streamIDs))
+	for i, id := range streamIDs {
+		resps[i] = &H2StreamResponse{StreamID: id}
+		pending[id] = resps[i]
+	}
+	for len(pending) > 0 {
+		f, err := c.ReadFrame(timeout)
+		if err != nil {
+			return resps, err
+		}
+		if f.StreamID == 0 {
+			for _, resp := range pending {
+				resp.Frames = append(resp.Frames, f)
+			}
+			if f.Type != http2.FrameGoAway {
+				continue
+			}
+			// streams above the last processed one will never be answered
+			for id, resp := range pending {
+				if id > f.LastStreamID {
+					resp.Reset = true
+					resp.ErrCode = f.ErrCode
+					delete(pending, id)
+				}
+			}
+			continue
+		}
+		resp, ok := pending[f.StreamID]
+		if !ok {
+			continue
+		}
+		resp.Frames = append(resp.Frames, f)
+		switch f.Type {
+		case http2.FrameRSTStream:
+			resp.Reset = true
+			resp.ErrCode = f.ErrCode
+			delete(pending, f.StreamID)
+		case http2.FrameHeaders:
+			if resp.Headers == nil || isInformational(resp.Headers) {
+				resp.Headers = f.Headers
+				resp.ReceivedAt = f.ReceivedAt
+			} else {
+				resp.Trailers = f.Headers
+			}
+			if f.Flags.Has(http2.FlagHeadersEndStream) {
+				delete(pending, f.StreamID)
+			}
+		case http2.FrameData:
+			resp.Body = append(resp.Body, f.Data...)
+			if f.Flags.Has(http2.FlagDataEndStream) {
+				delete(pending, f.StreamID)
+				continue
+			}
+			// keep the server sending, the connection window is replenished
+			// too. Padding counts against flow control, the frame length is used
+			if f.Length > 0 {
+				if err = c.WriteWindowUpdate(0, f.Length); err == nil {
+					err = c.WriteWindowUpdate(f.StreamID, f.Length)
+				}
+				if err != nil {
+					return resps, err
+				}
+			}
+		}
+	}
+	return resps, nil
+}
+
+func isInformational(headers []H2Header) bool {
+	for _, h := range headers {
+		if h.Name == ":status" {
+			return len(h.Value) == 3 && h.Value[0] == '1'
+		}
+	}
+	return false
+}
+
+func (c *H2Conn) Close() error {
+	return c.conn.Close()
+}
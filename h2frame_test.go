@@ -0,0 +1,113 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestH2Conn(t *testing.T) {
+	ts := testhttp.CreateH2Server(t)
+	defer ts.Close()
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+
+	conn, err := client.DialH2(context.Background(), ts.URL, &H2ConnOptions{
+		Settings: []http2.Setting{{ID: http2.SettingInitialWindowSize, Val: 1 << 20}},
+	})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	authority := strings.TrimPrefix(ts.URL, "https://")
+	request := func(path string, extra ...H2Header) []H2Header {
+		return append([]H2Header{
+			{":method", "POST"},
+			{":scheme", "https"},
+			{":authority", authority},
+			{":path", path},
+		}, extra...)
+	}
+
+	id := conn.NextStreamID()
+	require.Equal(t, uint32(1), id)
+	require.Nil(t, conn.WriteHeaders(id, request("/"), false))
+	require.Nil(t, conn.WriteData(id, []byte("ping"), true))
+	resp, err := conn.ReadResponse(id, 5*time.Second)
+	require.Nil(t, err)
+	require.False(t, resp.Reset)
+	require.Equal(t, "200", resp.Status())
+	require.Equal(t, "HTTP/2.0 POST ping", string(resp.Body))
+
+	// a header block split over CONTINUATION frames and a large response
+	conn.SetMaxFrameSize(16)
+	id = conn.NextStreamID()
+	require.Nil(t, conn.WriteHeaders(id, request("/?size=100000", H2Header{"x-padding", strings.Repeat("p", 64)}), true))
+	resp, err = conn.ReadResponse(id, 5*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, "200", resp.Status())
+	require.Len(t, resp.Body, 100000)
+
+	// the server rejects an invalid header name with a stream error
+	id = conn.NextStreamID()
+	require.Nil(t, conn.WriteHeaders(id, request("/", H2Header{"Bad Name", "x"}), true))
+	resp, err = conn.ReadResponse(id, 5*time.Second)
+	require.Nil(t, err)
+	require.True(t, resp.Reset)
+	require.Equal(t, http2.ErrCodeProtocol, resp.ErrCode)
+
+	require.Nil(t, conn.WritePing(false, [8]byte{1, 2, 3}))
+	for {
+		f, err := conn.ReadFrame(5 * time.Second)
+		require.Nil(t, err)
+		if f.Type == http2.FramePing {
+			require.True(t, f.Flags.Has(http2.FlagPingAck))
+			require.Equal(t, []byte{1, 2, 3, 0, 0, 0, 0, 0}, f.Data)
+			break
+		}
+	}
+}
+
+func TestH2Conn_Limits(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	conn, err := NewH2Conn(clientConn, &H2ConnOptions{SkipPreface: true, SkipSettings: true, MaxContinuations: 4})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	_ = hpack.NewEncoder(&buf).WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+	framer := http2.NewFramer(serverConn, serverConn)
+	increments := make(chan uint32, 2)
+	go func() {
+		_ = framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: buf.Bytes(), EndHeaders: true})
+		_ = framer.WriteDataPadded(1, false, []byte("abc"), make([]byte, 10))
+		for i := 0; i < 2; i++ {
+			if f, err := framer.ReadFrame(); err == nil {
+				increments <- f.(*http2.WindowUpdateFrame).Increment
+			}
+		}
+		_ = framer.WriteData(1, true, nil)
+
+		// CONTINUATION frames that never end the block
+		_ = framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: buf.Bytes()})
+		for framer.WriteContinuation(3, false, []byte{0x88}) == nil {
+		}
+	}()
+
+	resp, err := conn.ReadResponse(1, 5*time.Second)
+	require.Nil(t, err)
+	require.Equal(t, "abc", string(resp.Body))
+	// the pad length byte and the padding count against the windows
+	require.Equal(t, uint32(14), <-increments)
+	require.Equal(t, uint32(14), <-increments)
+
+	_, err = conn.ReadResponse(3, 5*time.Second)
+	require.ErrorIs(t, err, ErrH2HeaderBlockTooLarge)
+}
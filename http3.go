@@ -0,0 +1,242 @@
+package shttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/iami317/shttp/xtls"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrH3Proxy quic runs over udp and can't be sent through the http/socks proxy
+var ErrH3Proxy = errors.New("h3 can't be used through a proxy")
+
+// ErrH3Dialer quic can't use the unix socket or the custom dial function
+var ErrH3Dialer = errors.New("h3 can't be used with a unix socket or a custom dial function")
+
+// defaultAltSvcMaxAge rfc 7838, ma defaults to 24 hours
+const defaultAltSvcMaxAge = 24 * time.Hour
+
+// newH3Transport tls settings are shared with the tcp transports, the dial
+// honours the scope, the source addresses and the alternative endpoints learned
+// from Alt-Svc, enforced tls checks are bound to the origin host
+func newH3Transport(options *ClientOptions, tlsConfig *tls.Config, handshaker *xtls.Handshaker, s *scope, sources *sourcePool, altSvc *altSvcCache) *http3.Transport {
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{}
+	}
+	// http3 sets the h3 alpn itself
+	tlsConfig.NextProtos = nil
+	quicConfig := &quic.Config{}
+	if options.TLSHandshakeTimeout > 0 {
+		quicConfig.HandshakeIdleTimeout = time.Duration(options.TLSHandshakeTimeout) * time.Second
+	}
+	if options.IdleConnTimeout > 0 {
+		quicConfig.MaxIdleTimeout = time.Duration(options.IdleConnTimeout) * time.Second
+	}
+	return &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		QUICConfig:      quicConfig,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			origin, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsCfg = handshaker.ConfigFor(tlsCfg, origin)
+			if alt := altSvc.lookup(addr); alt != "" {
+				addr = alt
+			}
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			ips, err := resolveHost(ctx, host, options.IPFamily)
+			if err != nil {
+				return nil, err
+			}
+			addr = net.JoinHostPort(ips[0].String(), port)
+			if s != nil {
+				if err = s.dialControl("udp", addr, nil); err != nil {
+					return nil, err
+				}
+			}
+			if sources == nil {
+				return quic.DialAddr(ctx, addr, tlsCfg, cfg)
+			}
+			return dialQUICFrom(ctx, sources.pick(host, ips[0]), addr, tlsCfg, cfg)
+		},
+	}
+}
+
+// dialQUICFrom quic connection from a bound udp socket, the socket is closed with the connection
+func dialQUICFrom(ctx context.Context, source net.IP, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+	if source == nil {
+		return nil, fmt.Errorf("no source address for %s", addr)
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: source})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.Dial(ctx, udpConn, raddr, tlsCfg, cfg)
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	context.AfterFunc(conn.Context(), func() { udpConn.Close() })
+	return conn, nil
+}
+
+type altSvcEntry struct {
+	authority string
+	expires   time.Time
+}
+
+// altSvcCache h3 alternatives per origin host:port, a nil cache knows nothing
+type altSvcCache struct {
+	mu      sync.Mutex
+	entries map[string]*altSvcEntry
+}
+
+func newAltSvcCache() *altSvcCache {
+	return &altSvcCache{entries: make(map[string]*altSvcEntry)}
+}
+
+// lookup the h3 endpoint advertised for origin, empty when unknown or expired
+func (c *altSvcCache) lookup(origin string) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[origin]
+	if !ok {
+		return ""
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(c.entries, origin)
+		return ""
+	}
+	return entry.authority
+}
+
+// update from the Alt-Svc header values of a response received for origin
+func (c *altSvcCache) update(origin string, values []string) {
+	if c == nil || len(values) == 0 {
+		return
+	}
+	authority, maxAge, cleared := parseAltSvc(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cleared {
+		delete(c.entries, origin)
+		return
+	}
+	if authority == "" {
+		return
+	}
+	if strings.HasPrefix(authority, ":") {
+		host, _, _ := net.SplitHostPort(origin)
+		authority = net.JoinHostPort(host, authority[1:])
+	}
+	c.entries[origin] = &altSvcEntry{authority: authority, expires: time.Now().Add(maxAge)}
+}
+
+func (c *altSvcCache) remove(origin string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, origin)
+	c.mu.Unlock()
+}
+
+// parseAltSvc first h3 alternative of the header, cleared for Alt-Svc: clear
+func parseAltSvc(values []string) (authority string, maxAge time.Duration, cleared bool) {
+	for _, value := range values {
+		for _, alt := range strings.Split(value, ",") {
+			alt = strings.TrimSpace(alt)
+			if alt == "clear" {
+				return "", 0, true
+			}
+			params := strings.Split(alt, ";")
+			protocol, quoted, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if !ok || protocol != "h3" {
+				continue
+			}
+			authority, err := strconv.Unquote(quoted)
+			if err != nil || authority == "" {
+				continue
+			}
+			maxAge = defaultAltSvcMaxAge
+			for _, param := range params[1:] {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if k != "ma" {
+					continue
+				}
+				if seconds, err := strconv.Atoi(v); err == nil {
+					maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+			if maxAge <= 0 {
+				continue
+			}
+			return authority, maxAge, false
+		}
+	}
+	return "", 0, false
+}
+
+// altSvcOrigin host:port key of an https url
+func altSvcOrigin(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// roundTripAltSvc tries the h3 alternative of the origin, falling back to tcp
+// and forgetting the alternative when quic fails
+func (t *protocolTransport) roundTripAltSvc(req *http.Request, tcp http.RoundTripper) (*http.Response, error) {
+	origin := altSvcOrigin(req.URL)
+	if t.altSvc.lookup(origin) != "" {
+		resp, err := t.h3.RoundTrip(req)
+		if err == nil {
+			return resp, nil
+		}
+		t.altSvc.remove(origin)
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+	resp, err := tcp.RoundTrip(req)
+	if err == nil {
+		t.altSvc.update(origin, resp.Header.Values("Alt-Svc"))
+	}
+	return resp, err
+}
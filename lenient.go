@@ -0,0 +1,307 @@
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"golang.org/x/net/http/httpguts"
+	"io"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Anomalies of a leniently parsed response, see Response.GetAnomalies
+const (
+	AnomalyHTTP09              = "http/0.9"                              // no status line, the whole response is the body
+	AnomalyBareLF              = "bare-lf"                               // lines end with \n instead of \r\n
+	AnomalyStatusLine          = "invalid-status-line"                   // malformed version, status code or separators
+	AnomalyHeaderLine          = "invalid-header-line"                   // header line without a colon or with an invalid name or value
+	AnomalyObsFold             = "obs-fold"                              // header value continued on the next line
+	AnomalyUnterminatedHeaders = "unterminated-headers"                  // the response ended before the blank line after the headers
+	AnomalyDuplicateCL         = "duplicate-content-length"              // more than one Content-Length value
+	AnomalyInvalidCL           = "invalid-content-length"                // Content-Length isn't a number, the body is read until close
+	AnomalyCLWithTE            = "content-length-with-transfer-encoding" // both framings, Transfer-Encoding wins
+	AnomalyChunked             = "invalid-chunked-encoding"              // bad size line or chunk terminator, the rest is read until close
+	AnomalyTruncated           = "truncated-body"                        // the response ended before its framing said
+	AnomalyTrailingData        = "trailing-data"                         // bytes after the end of the framed body
+)
+
+// lenientMaxHeader size allowed for the status line and headers on top of MaxRespBodySize
+const lenientMaxHeader = 1 << 20
+
+var statusCodeRe = regexp.MustCompile(`\b\d{3}\b`)
+
+// lenientResponse what the lenient parser saw besides the response itself
+type lenientResponse struct {
+	raw       []byte
+	anomalies []string
+}
+
+// lenientReader reads a response until the end of the stream, any read error
+// ends it
+type lenientReader struct {
+	br        *bufio.Reader
+	anomalies []string
+}
+
+func (l *lenientReader) anomaly(anomaly string) {
+	for _, a := range l.anomalies {
+		if a == anomaly {
+			return
+		}
+	}
+	l.anomalies = append(l.anomalies, anomaly)
+}
+
+// line next line without its terminator, terminated false when the stream ended before a \n
+func (l *lenientReader) line() (line string, terminated bool) {
+	b, err := l.br.ReadBytes('\n')
+	if err != nil {
+		return string(b), false
+	}
+	b = b[:len(b)-1]
+	if len(b) > 0 && b[len(b)-1] == '\r' {
+		b = b[:len(b)-1]
+	} else {
+		l.anomaly(AnomalyBareLF)
+	}
+	return string(b), true
+}
+
+// read n bytes of body, false when the stream ended first
+func (l *lenientReader) read(n int64) ([]byte, bool) {
+	buf := make([]byte, n)
+	m, err := io.ReadFull(l.br, buf)
+	if err != nil {
+		return buf[:m], false
+	}
+	return buf, true
+}
+
+func (l *lenientReader) readAll(max int64) []byte {
+	// a read error or timeout ends the body like the server closing would
+	b, _ := io.ReadAll(io.LimitReader(l.br, max))
+	return b
+}
+
+// readLenientResponse reads the response to req from r however broken it is,
+// the body is read whole, at most max bytes, and everything received is kept
+// as raw. Only a response that never started or a canceled ctx is an error
+func readLenientResponse(ctx context.Context, r io.Reader, req *http.Request, max int64) (*http.Response, *lenientResponse, error) {
+	var raw bytes.Buffer
+	l := &lenientReader{br: bufio.NewReader(io.TeeReader(io.LimitReader(r, max+lenientMaxHeader), &raw))}
+	resp := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+		Close:      true,
+	}
+	var body []byte
+
+	peek, err := l.br.Peek(5)
+	switch {
+	case len(peek) == 0:
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	case !strings.EqualFold(string(peek), "HTTP/"):
+		l.anomaly(AnomalyHTTP09)
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/0.9", 0, 9
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		body = l.readAll(max)
+	default:
+		line, _ := l.line()
+		parseLenientStatus(l, resp, line)
+		if parseLenientHeaders(l, resp.Header) {
+			body = readLenientBody(l, resp, req.Method, max)
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, ctxErr
+	}
+
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, &lenientResponse{raw: raw.Bytes(), anomalies: l.anomalies}, nil
+}
+
+// parseLenientStatus version and status code of line, best effort
+func parseLenientStatus(l *lenientReader, resp *http.Response, line string) {
+	proto, rest, _ := strings.Cut(line, " ")
+	code, reason, _ := strings.Cut(rest, " ")
+	major, minor, ok := http.ParseHTTPVersion(strings.ToUpper(proto))
+	if ok {
+		resp.ProtoMajor, resp.ProtoMinor = major, minor
+	}
+	resp.Proto = proto
+	status, err := strconv.Atoi(code)
+	if !ok || len(code) != 3 || err != nil {
+		l.anomaly(AnomalyStatusLine)
+		status = 0
+		if found := statusCodeRe.FindString(rest); found != "" {
+			status, _ = strconv.Atoi(found)
+			_, reason, _ = strings.Cut(rest, found)
+			reason = strings.TrimSpace(reason)
+		}
+	}
+	resp.StatusCode = status
+	resp.Status = strings.TrimSpace(strconv.Itoa(status) + " " + reason)
+}
+
+// parseLenientHeaders headers up to the blank line, false when the response ended before it
+func parseLenientHeaders(l *lenientReader, header http.Header) bool {
+	var last string
+	for {
+		line, terminated := l.line()
+		if !terminated {
+			l.anomaly(AnomalyUnterminatedHeaders)
+			return false
+		}
+		if line == "" {
+			return true
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			l.anomaly(AnomalyObsFold)
+			if last == "" {
+				l.anomaly(AnomalyHeaderLine)
+				continue
+			}
+			values := header[last]
+			values[len(values)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		if !ok || !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+			l.anomaly(AnomalyHeaderLine)
+		}
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			last = ""
+			continue
+		}
+		last = textproto.CanonicalMIMEHeaderKey(name)
+		header[last] = append(header[last], value)
+	}
+}
+
+// readLenientBody body by the framing the headers claim, decoded when chunked
+func readLenientBody(l *lenientReader, resp *http.Response, method string, max int64) []byte {
+	if method == http.MethodHead || resp.StatusCode/100 == 1 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return trailingData(l, nil)
+	}
+
+	length := int64(-1)
+	var lengths []string
+	for _, value := range resp.Header.Values("Content-Length") {
+		for _, v := range strings.Split(value, ",") {
+			lengths = append(lengths, strings.TrimSpace(v))
+		}
+	}
+	if len(lengths) > 1 {
+		l.anomaly(AnomalyDuplicateCL)
+	}
+	if len(lengths) > 0 {
+		n, err := strconv.ParseInt(lengths[0], 10, 64)
+		if err != nil || n < 0 {
+			l.anomaly(AnomalyInvalidCL)
+		} else {
+			length = n
+		}
+	}
+
+	if te := resp.Header.Values("Transfer-Encoding"); len(te) > 0 {
+		if len(lengths) > 0 {
+			l.anomaly(AnomalyCLWithTE)
+		}
+		codings := strings.Split(te[len(te)-1], ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			resp.TransferEncoding = []string{"chunked"}
+			return readLenientChunked(l, resp, max)
+		}
+		// not chunked last, the body ends with the connection
+		return l.readAll(max)
+	}
+	if length < 0 {
+		return l.readAll(max)
+	}
+	body, ok := l.read(min(length, max))
+	if !ok {
+		l.anomaly(AnomalyTruncated)
+		return body
+	}
+	return trailingData(l, body)
+}
+
+// readLenientChunked decodes chunks until the last one, whatever doesn't parse
+// is kept as is with the rest of the stream
+func readLenientChunked(l *lenientReader, resp *http.Response, max int64) []byte {
+	var body []byte
+	for {
+		line, terminated := l.line()
+		if !terminated && line == "" {
+			l.anomaly(AnomalyTruncated)
+			return body
+		}
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if !terminated || err != nil || size < 0 {
+			l.anomaly(AnomalyChunked)
+			body = append(body, line...)
+			if terminated {
+				body = append(body, '\n')
+			}
+			return append(body, l.readAll(max-int64(len(body)))...)
+		}
+		if size == 0 {
+			break
+		}
+		chunk, ok := l.read(min(size, max-int64(len(body))))
+		body = append(body, chunk...)
+		if !ok {
+			l.anomaly(AnomalyTruncated)
+			return body
+		}
+		if int64(len(body)) >= max {
+			return body
+		}
+		if end, _ := l.line(); end != "" {
+			l.anomaly(AnomalyChunked)
+			return append(append(body, end...), l.readAll(max-int64(len(body)))...)
+		}
+	}
+	for {
+		line, terminated := l.line()
+		if !terminated {
+			l.anomaly(AnomalyTruncated)
+			return body
+		}
+		if line == "" {
+			return trailingData(l, body)
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			if resp.Trailer == nil {
+				resp.Trailer = make(http.Header)
+			}
+			resp.Trailer.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		} else {
+			l.anomaly(AnomalyHeaderLine)
+		}
+	}
+}
+
+// trailingData notes bytes that came after the end of the body, they are kept in the raw response only
+func trailingData(l *lenientReader, body []byte) []byte {
+	if l.br.Buffered() > 0 {
+		l.anomaly(AnomalyTrailingData)
+	}
+	return body
+}
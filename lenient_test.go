@@ -0,0 +1,183 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"github.com/iami317/shttp/testutils/tcp"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_LenientResponse(t *testing.T) {
+	responses := map[string]string{
+		"/bare-lf":   "HTTP/1.1 200 OK\nContent-Length: 2\n\nhi",
+		"/status":    "HTTP/1.1  404 Not Found\r\nContent-Length: 2\r\n\r\nno",
+		"/dup-cl":    "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nContent-Length: 5\r\n\r\nhello",
+		"/http09":    "hello world",
+		"/garbage":   "HTTP/1.1 200 OK\r\nX-A: 1\r\n folded\r\ngarbage line\r\n\r\nbody",
+		"/chunked":   "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n3\r\nabc\r\nzz\r\nrest",
+		"/truncated": "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc",
+	}
+	ts := tcp.NewTCPServer(func(conn net.Conn) {
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(responses[req.URL.Path]))
+	})
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.LenientResponse = true
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	for _, c := range []struct {
+		path      string
+		status    int
+		body      string
+		anomalies []string
+	}{
+		{"/bare-lf", 200, "hi", []string{AnomalyBareLF}},
+		{"/status", 404, "no", []string{AnomalyStatusLine}},
+		{"/dup-cl", 200, "he", []string{AnomalyDuplicateCL, AnomalyTrailingData}},
+		{"/http09", 200, "hello world", []string{AnomalyHTTP09}},
+		{"/garbage", 200, "body", []string{AnomalyObsFold, AnomalyHeaderLine}},
+		{"/chunked", 200, "abczz\nrest", []string{AnomalyCLWithTE, AnomalyChunked}},
+		{"/truncated", 200, "abc", []string{AnomalyTruncated}},
+	} {
+		hr, _ := http.NewRequest("GET", "http://"+ts.URL+c.path, nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err, c.path)
+		require.Equal(t, c.status, resp.GetStatus(), c.path)
+		require.Equal(t, c.body, string(resp.GetBody()), c.path)
+		require.Equal(t, c.anomalies, resp.GetAnomalies(), c.path)
+		raw, err := resp.GetRaw()
+		require.Nil(t, err)
+		require.Equal(t, responses[c.path], string(raw), c.path)
+		if c.path == "/http09" {
+			require.Equal(t, "http/0.9", resp.GetProtocol())
+		}
+		if c.path == "/garbage" {
+			require.Equal(t, "1 folded", resp.GetHeaders().Get("X-A"))
+		}
+	}
+
+	// net/http gives up on the same response
+	strict, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", "http://"+ts.URL+"/dup-cl", nil)
+	_, err = strict.Do(context.Background(), &Request{RawRequest: hr})
+	require.NotNil(t, err)
+
+	// well formed responses have no anomalies
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Close", r.Header.Get("Connection"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("streamed"))
+	}))
+	defer server.Close()
+	hr, _ = http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, "streamed", string(resp.GetBody()))
+	require.Equal(t, "close", resp.GetHeaders().Get("X-Close"))
+	require.Empty(t, resp.GetAnomalies())
+}
+
+func TestClient_LenientResponseCached(t *testing.T) {
+	const raw = "HTTP/1.1 200 OK\nCache-Control: max-age=60\nContent-Length: 2\n\nhi"
+	var hits atomic.Int32
+	ts := tcp.NewTCPServer(func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		hits.Add(1)
+		_, _ = conn.Write([]byte(raw))
+	})
+	defer ts.Close()
+
+	dedup := DefaultClientOptions()
+	dedup.Dedup = &DedupOptions{CacheTTL: 60, Methods: []string{http.MethodGet}}
+	cache := DefaultClientOptions()
+	cache.Cache = &CacheOptions{}
+	for name, options := range map[string]*ClientOptions{"dedup": dedup, "cache": cache} {
+		hits.Store(0)
+		options.LenientResponse = true
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			hr, _ := http.NewRequest("GET", "http://"+ts.URL+"/"+name, nil)
+			resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+			require.Nil(t, err, name)
+			require.Equal(t, "hi", string(resp.GetBody()), name)
+			require.Equal(t, []string{AnomalyBareLF}, resp.GetAnomalies(), name)
+			wire, err := resp.GetRaw()
+			require.Nil(t, err)
+			require.Equal(t, raw, string(wire), name)
+		}
+		require.Equal(t, int32(1), hits.Load(), name)
+	}
+}
+
+func TestClient_LenientResponseRedirect(t *testing.T) {
+	responses := map[string]string{
+		"/moved":   "HTTP/1.1 302 Found\nLocation: /bare-lf\nContent-Length: 0\n\n",
+		"/bare-lf": "HTTP/1.1 200 OK\nContent-Length: 2\n\nhi",
+	}
+	ts := tcp.NewTCPServer(func(conn net.Conn) {
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(responses[req.URL.Path]))
+	})
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.LenientResponse = true
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", "http://"+ts.URL+"/moved", nil)
+	req := (&Request{RawRequest: hr}).EnableTrace()
+	resp, err := client.Do(context.Background(), req)
+	require.Nil(t, err)
+	require.Equal(t, "hi", string(resp.GetBody()))
+	require.Equal(t, "/bare-lf", resp.GetUrl().Path)
+	require.Len(t, resp.GetRedirectChain(), 1)
+	require.Equal(t, []string{AnomalyBareLF}, resp.GetAnomalies())
+	raw, err := resp.GetRaw()
+	require.Nil(t, err)
+	require.Equal(t, responses["/bare-lf"], string(raw))
+	require.NotNil(t, req.getTraceInfo().RemoteAddr)
+
+	// a session dials its connection, the server closes it after every response
+	session, err := client.NewSession(nil)
+	require.Nil(t, err)
+	defer session.Close()
+	for i := 0; i < 2; i++ {
+		hr, _ = http.NewRequest("GET", "http://"+ts.URL+"/bare-lf", nil)
+		resp, err = session.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, []string{AnomalyBareLF}, resp.GetAnomalies())
+	}
+	require.Equal(t, 1, session.Reconnects())
+
+	// h2 isn't read leniently, the protocol is kept
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	hr, _ = http.NewRequest("GET", server.URL, nil)
+	resp, err = client.Do(context.Background(), (&Request{RawRequest: hr}).SetProtocol(ProtocolH2))
+	require.Nil(t, err)
+	require.Equal(t, ProtocolH2, resp.GetProtocol())
+	require.Empty(t, resp.GetAnomalies())
+}
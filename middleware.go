@@ -1,11 +1,15 @@
 package shttp
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"github.com/iami317/shttp/xtls"
 	"github.com/thoas/go-funk"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 )
 
 //‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
@@ -21,6 +25,10 @@ func verifyRequestMethod(req *Request, c *Client) error {
 	return nil
 }
 
+func verifyRequestScope(req *Request, c *Client) error {
+	return c.scope.checkURL(req.RawRequest.URL)
+}
+
 func createHTTPRequest(req *Request, c *Client) error {
 	// enable trace
 	if req.trace {
@@ -45,7 +53,30 @@ func createHTTPRequest(req *Request, c *Client) error {
 			})
 		}
 	}
-	// add ctx
+	// add ctx, redirect hops find the Request through it
+	req.ctx = context.WithValue(req.GetContext(), requestContextKey{}, req)
+	if req.protocol != "" {
+		if err := verifyProtocol(req.protocol); err != nil {
+			return err
+		}
+		req.ctx = context.WithValue(req.ctx, protocolContextKey{}, req.protocol)
+	}
+	// per host client certificate selection
+	req.ctx = xtls.ContextWithServerName(req.ctx, req.currentHost)
+	customHello := c.ClientOptions.TlsOptions != nil && c.ClientOptions.TlsOptions.CustomClientHello()
+	req.ctx = httptrace.WithClientTrace(req.ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			req.localAddr = info.Conn.LocalAddr()
+			req.remoteAddr = info.Conn.RemoteAddr()
+			if !customHello {
+				return
+			}
+			if conn, ok := info.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+				state := conn.ConnectionState()
+				req.connState = &state
+			}
+		},
+	})
 	req.RawRequest = req.RawRequest.WithContext(req.GetContext())
 	return nil
 }
@@ -17,16 +17,21 @@ import (
 type ClientOptions struct {
 	Proxy string `json:"proxy" yaml:"proxy" #:"漏洞扫描时使用的代理, 如: http://127.0.0.1:8080. 如需设置多个代理, 请使用 proxy_rule 或自行创建上层代理"`
 	//ProxyRule           []Rule       `json:"proxy_rule" yaml:"proxy_rule" #:"漏洞扫描使用多个代理的配置规则, 具体请参照文档"`
-	DialTimeout         int  `json:"dial_timeout" yaml:"dial_timeout" #:"建立 tcp 连接的超时时间"`
-	ReadTimeout         int  `json:"read_timeout" yaml:"read_timeout" #:"读取 http 响应的超时时间, 不可太小, 否则会影响到 sql 时间盲注的判断"`
-	MaxConnsPerHost     int  `json:"max_conns_per_host" yaml:"max_conns_per_host" #:"同一 host 最大允许的连接数, 可以根据目标主机性能适当增大"`
-	EnableHTTP2         bool `json:"enable_http2" yaml:"enable_http2" #:"是否启用 http2, 开启可以提升部分网站的速度, 但目前不稳定有崩溃的风险"`
-	IdleConnTimeout     int  `json:"-" yaml:"-"`
-	MaxIdleConns        int  `json:"-" yaml:"-"`
-	TLSHandshakeTimeout int  `json:"-" yaml:"-"`
+	DialTimeout         int    `json:"dial_timeout" yaml:"dial_timeout" #:"建立 tcp 连接的超时时间"`
+	ReadTimeout         int    `json:"read_timeout" yaml:"read_timeout" #:"读取 http 响应的超时时间, 不可太小, 否则会影响到 sql 时间盲注的判断"`
+	MaxConnsPerHost     int    `json:"max_conns_per_host" yaml:"max_conns_per_host" #:"同一 host 最大允许的连接数, 可以根据目标主机性能适当增大"`
+	EnableHTTP2         bool   `json:"enable_http2" yaml:"enable_http2" #:"是否启用 http2, 等同于 protocol: h2, protocol 不为空时忽略"`
+	Protocol            string `json:"protocol" yaml:"protocol" #:"协议, 可选: http/1.1, h2(tls alpn 协商), h2c(明文 prior knowledge), h2c-upgrade(明文 Upgrade 升级), h3(quic), 为空则按 enable_http2"`
+	AltSvc              bool   `json:"alt_svc" yaml:"alt_svc" #:"是否按响应的 Alt-Svc 头把后续 https 请求升级到 h3, quic 失败时回退 tcp, 走代理时不生效"`
+	IdleConnTimeout     int    `json:"-" yaml:"-"`
+	MaxIdleConns        int    `json:"-" yaml:"-"`
+	TLSHandshakeTimeout int    `json:"-" yaml:"-"`
 
 	FailRetries       int                 `json:"fail_retries" yaml:"fail_retries" #:"请求失败的重试次数, 0 则不重试"`
 	MaxRedirect       int                 `json:"max_redirect" yaml:"max_redirect" #:"单个请求最大允许的跳转数"`
+	RedirectPolicies  []string            `json:"redirect_policies" yaml:"redirect_policies" #:"跳转策略, 可选: same-host, same-domain, no-downgrade, 不满足时停止跳转并返回当前响应"`
+	RedirectCallback  RedirectCallback    `json:"-" yaml:"-"`
+	BrowserRedirect   bool                `json:"browser_redirect" yaml:"browser_redirect" #:"是否跟随 meta refresh 及 js location 跳转, 仅跳转 client 生效"`
 	MaxRespBodySize   int64               `json:"max_resp_body_size" yaml:"max_resp_body_size" #:"最大允许的响应大小, 默认 4M"`
 	MaxQPS            int                 `json:"max_qps" yaml:"max_qps" #:"每秒最大请求数"`
 	AllowMethods      []string            `json:"allow_methods" yaml:"allow_methods" #:"允许的请求方法"`
@@ -36,7 +41,17 @@ type ClientOptions struct {
 	Debug             bool                `json:"http_debug" yaml:"http_debug" #:"是否启用 debug 模式, 开启 request trace"`
 	DisableKeepAlives bool                `json:"disable_keep_alives" yaml:"disable_keep_alives" #:"是否禁用 keepalives"`
 	Limiter           *rate.Limiter       `json:"-" yaml:"-"`
-	SoloConn          bool                `json:"solo_conn" yaml:"solo_conn" #:"是否启用单连接模式"`
+	SoloConn          bool                `json:"solo_conn" yaml:"solo_conn" #:"是否启用单连接模式, 每个请求使用独立的新连接且用完即关闭, 需要复用同一连接时使用 Client.NewSession"`
+	Dedup             *DedupOptions       `json:"dedup" yaml:"dedup" #:"合并相同的进行中请求并缓存响应, 为空则不启用"`
+	Cache             *CacheOptions       `json:"cache" yaml:"cache" #:"遵循 Cache-Control/ETag/Last-Modified 的 http 缓存, 为空则不启用"`
+	Scope             *ScopeOptions       `json:"scope" yaml:"scope" #:"请求范围限制, 请求、跳转及 dns 解析后均会检查, 为空不限制"`
+	SourceIPs         []string            `json:"source_ips" yaml:"source_ips" #:"出口 ip 池, 一个则固定绑定, 多个按 source_rotation 轮换, 走代理时绑定的是连接代理的出口"`
+	Interface         string              `json:"interface" yaml:"interface" #:"绑定的网卡名, 使用网卡上的地址作为出口 ip 池, 与 source_ips 二选一"`
+	IPFamily          string              `json:"ip_family" yaml:"ip_family" #:"ip 协议族, 可选: ipv4, ipv6(只使用该协议族), prefer-ipv4, prefer-ipv6(优先使用, 失败后尝试另一协议族), 为空则使用系统默认"`
+	SourceRotation    string              `json:"source_rotation" yaml:"source_rotation" #:"出口 ip 轮换方式, 可选: round-robin, random, per-host(同一目标固定同一出口), 默认 round-robin"`
+	UnixSocket        string              `json:"unix_socket" yaml:"unix_socket" #:"unix socket 路径, 设置后所有连接都连到该 socket, url 中的 host 仅用于 Host 头及 tls sni, 不支持 h3"`
+	DialContext       DialFunc            `json:"-" yaml:"-"`
+	LenientResponse   bool                `json:"lenient_response" yaml:"lenient_response" #:"是否宽松解析响应, 畸形响应(裸 LF、非法状态行、重复 Content-Length、HTTP/0.9 等)也会返回并记录协议异常, 开启后 http/1.1 请求各走独立连接, 跳转照常跟随, h2/h3 请求不受影响"`
 }
 
 func (o *ClientOptions) SetLimiter() *ClientOptions {
@@ -59,6 +74,8 @@ func (o *ClientOptions) Clone() *ClientOptions {
 		newOptions.AllowMethods[i] = value
 	}
 	//newOptions.AllowMethods = append(o.AllowMethods[0:0], o.AllowMethods...)
+	newOptions.RedirectPolicies = append([]string(nil), o.RedirectPolicies...)
+	newOptions.SourceIPs = append([]string(nil), o.SourceIPs...)
 	newHeaders := make(map[string]string)
 	for k, v := range o.Headers {
 		newHeaders[k] = v
@@ -69,8 +86,16 @@ func (o *ClientOptions) Clone() *ClientOptions {
 		newCookies[k] = v
 	}
 	newOptions.Cookies = newCookies
-	newTlsOptions := *o.TlsOptions
-	newOptions.TlsOptions = &newTlsOptions
+	newOptions.TlsOptions = o.TlsOptions.Clone()
+	if o.Dedup != nil {
+		newOptions.Dedup = o.Dedup.Clone()
+	}
+	if o.Cache != nil {
+		newOptions.Cache = o.Cache.Clone()
+	}
+	if o.Scope != nil {
+		newOptions.Scope = o.Scope.Clone()
+	}
 	return &newOptions
 }
 
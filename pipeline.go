@@ -0,0 +1,280 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// PipelineOptions http/1.1 pipelining, connections go through the proxy when there is one
+type PipelineOptions struct {
+	Depth       int `json:"depth" yaml:"depth" #:"每个连接上已发送未响应的最大请求数, 默认 10"`
+	Connections int `json:"connections" yaml:"connections" #:"并发连接数, 默认 1"`
+	Retries     int `json:"retries" yaml:"retries" #:"连接未返回任何响应就被关闭时, 请求的最大重发次数"`
+	Timeout     int `json:"timeout" yaml:"timeout" #:"读取单个响应的超时时间(秒), 0 则使用 read_timeout"`
+	// ResendNonIdempotent unanswered POST, PUT... are sent again on the next
+	// connection too, the server may have processed them already
+	ResendNonIdempotent bool `json:"resend_non_idempotent" yaml:"resend_non_idempotent" #:"连接关闭时是否重发未响应的非幂等请求(POST 等), 服务端可能已处理过"`
+}
+
+// ErrPipelineNotResent an unanswered non idempotent request wasn't sent again,
+// see PipelineOptions.ResendNonIdempotent
+var ErrPipelineNotResent = errors.New("pipeline: unanswered non idempotent request not resent")
+
+// DefaultPipelineOptions depth 10 on one connection
+func DefaultPipelineOptions() *PipelineOptions {
+	return &PipelineOptions{Depth: 10, Connections: 1, Retries: 2}
+}
+
+// PipelineResult Err is set instead of Response when the request failed
+type PipelineResult struct {
+	Request  *Request
+	Response *Response
+	Err      error
+}
+
+// Pipeline sends requests to one origin back to back on keep-alive
+// connections without waiting for the responses, responses are attributed in
+// order. When the server closes a connection early the unanswered idempotent
+// requests are resent on a new one whose depth is what the old one managed to
+// answer, down to 1 (no pipelining), the others fail with ErrPipelineNotResent
+// unless ResendNonIdempotent. Requests run through the request middlewares, the
+// limiter and the cookie jar but are not redirected.
+func (c *Client) Pipeline(ctx context.Context, reqs []*Request, options *PipelineOptions) ([]*PipelineResult, error) {
+	if len(reqs) == 0 {
+		return nil, errors.New("pipeline: no requests")
+	}
+	if options == nil {
+		options = DefaultPipelineOptions()
+	}
+	u := reqs[0].RawRequest.URL
+	for _, req := range reqs[1:] {
+		if other := req.RawRequest.URL; other.Scheme != u.Scheme || other.Host != u.Host {
+			return nil, errors.New("pipeline: requests must share one origin")
+		}
+	}
+
+	p := &pipeline{
+		client:   c,
+		ctx:      ctx,
+		origin:   u,
+		options:  options,
+		timeout:  time.Duration(options.Timeout) * time.Second,
+		results:  make([]*PipelineResult, len(reqs)),
+		raws:     make([][]byte, len(reqs)),
+		attempts: make([]int, len(reqs)),
+	}
+	if p.timeout <= 0 {
+		p.timeout = time.Duration(c.ClientOptions.ReadTimeout) * time.Second
+	}
+	for i, req := range reqs {
+		p.results[i] = &PipelineResult{Request: req}
+		if err := c.prepareDirect(ctx, req); err != nil {
+			return nil, err
+		}
+		raw, err := serializeRequest(ctx, req, false)
+		if err != nil {
+			return nil, err
+		}
+		p.raws[i] = raw
+		p.queue = append(p.queue, i)
+	}
+
+	connections := options.Connections
+	if connections <= 0 {
+		connections = 1
+	}
+	depth := options.Depth
+	if depth <= 0 {
+		depth = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(connections)
+	for i := 0; i < connections; i++ {
+		go func() {
+			defer wg.Done()
+			p.work(depth)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range p.results {
+		if result.Response == nil {
+			continue
+		}
+		if err := c.finishDirect(result.Response); err != nil {
+			result.Response, result.Err = nil, err
+		}
+	}
+	return p.results, nil
+}
+
+type pipeline struct {
+	client  *Client
+	ctx     context.Context
+	origin  *url.URL
+	options *PipelineOptions
+	timeout time.Duration
+
+	results  []*PipelineResult
+	raws     [][]byte
+	attempts []int
+
+	mu    sync.Mutex
+	queue []int
+}
+
+func (p *pipeline) pop() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		return 0, false
+	}
+	idx := p.queue[0]
+	p.queue = p.queue[1:]
+	return idx, true
+}
+
+// requeue puts unanswered requests back in front, keeping their order
+func (p *pipeline) requeue(idxs []int) {
+	p.mu.Lock()
+	p.queue = append(append([]int(nil), idxs...), p.queue...)
+	p.mu.Unlock()
+}
+
+// work runs connections until the queue is empty
+func (p *pipeline) work(depth int) {
+	for {
+		if err := p.ctx.Err(); err != nil {
+			for idx, ok := p.pop(); ok; idx, ok = p.pop() {
+				p.results[idx].Err = err
+			}
+			return
+		}
+		p.mu.Lock()
+		empty := len(p.queue) == 0
+		p.mu.Unlock()
+		if empty {
+			return
+		}
+		depth = p.runConn(depth)
+	}
+}
+
+// runConn one connection, returns the depth for the next one
+func (p *pipeline) runConn(depth int) int {
+	conn, err := p.client.dialURL(p.ctx, p.origin, []string{"http/1.1"})
+	if err != nil {
+		if idx, ok := p.pop(); ok {
+			p.results[idx].Err = err
+		}
+		return depth
+	}
+	stopCtx := context.AfterFunc(p.ctx, func() { conn.Close() })
+	defer stopCtx()
+
+	// the reader holds one request, the channel the others in flight
+	outstanding := make(chan int, depth-1)
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		defer close(outstanding)
+		for {
+			idx, ok := p.pop()
+			if !ok {
+				return
+			}
+			select {
+			case outstanding <- idx:
+			case <-stop:
+				p.requeue([]int{idx})
+				return
+			}
+			p.results[idx].Request.setSendAt()
+			if _, err := conn.Write(p.raws[idx]); err != nil {
+				return
+			}
+		}
+	}()
+
+	var (
+		answered int
+		pending  []int
+		readErr  error
+	)
+	br := bufio.NewReader(conn)
+	for idx := range outstanding {
+		req := p.results[idx].Request
+		_ = conn.SetReadDeadline(time.Now().Add(p.timeout))
+		resp, err := http.ReadResponse(br, req.RawRequest)
+		if err != nil {
+			pending, readErr = append(pending, idx), err
+			break
+		}
+		response, err := p.client.newDirectResponse(req, resp, conn, time.Now())
+		if err != nil {
+			pending, readErr = append(pending, idx), err
+			break
+		}
+		p.results[idx].Response = response
+		answered++
+		if resp.Close {
+			break
+		}
+	}
+	close(stop)
+	conn.Close()
+	<-writerDone
+	for idx := range outstanding {
+		pending = append(pending, idx)
+	}
+	if len(pending) == 0 {
+		return depth
+	}
+	// the server may have processed what it didn't answer
+	if !p.options.ResendNonIdempotent {
+		resend := pending[:0]
+		for _, idx := range pending {
+			if replayableMethod(p.results[idx].Request.GetMethod()) {
+				resend = append(resend, idx)
+			} else {
+				p.results[idx].Err = ErrPipelineNotResent
+			}
+		}
+		pending = resend
+	}
+
+	if answered == 0 {
+		// the first request may be what breaks the connection, give up on it eventually
+		if len(pending) > 0 {
+			head := pending[0]
+			p.attempts[head]++
+			if p.attempts[head] > p.options.Retries {
+				if readErr == nil {
+					readErr = errors.New("pipeline: connection closed without response")
+				}
+				p.results[head].Err = readErr
+				pending = pending[1:]
+			}
+		}
+		depth = 1
+	} else if answered < depth {
+		depth = answered
+	}
+	p.requeue(pending)
+	return depth
+}
+
+// replayableMethod methods sent again on a new connection, as net/http does
+func replayableMethod(method string) bool {
+	switch method {
+	case MethodGet, MethodHead, MethodOptions, MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package shttp
+
+import (
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Pipeline(t *testing.T) {
+	// answers only after 3 requests arrived, then closes the connection
+	target := testhttp.CreatePipelineServer(t, 3)
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+
+	var reqs []*Request
+	for i := 0; i < 18; i++ {
+		hr, _ := http.NewRequest("GET", target+"/item/"+strconv.Itoa(i), nil)
+		reqs = append(reqs, &Request{RawRequest: hr})
+	}
+	start := time.Now()
+	results, err := client.Pipeline(context.Background(), reqs, DefaultPipelineOptions())
+	require.Nil(t, err)
+	// every connection was answered without waiting for the server's read timeout
+	require.Less(t, time.Since(start), 900*time.Millisecond)
+	for i, result := range results {
+		require.Nil(t, result.Err, i)
+		require.Same(t, reqs[i], result.Request)
+		require.Equal(t, "/item/"+strconv.Itoa(i), string(result.Response.GetBody()))
+		require.Equal(t, strconv.Itoa(i%3+1), result.Response.GetHeaders().Get("X-Conn-Request"))
+	}
+
+	// spread over connections, a last partial batch waits for the timeout
+	options := DefaultPipelineOptions()
+	options.Connections = 3
+	results, err = client.Pipeline(context.Background(), reqs, options)
+	require.Nil(t, err)
+	for i, result := range results {
+		require.Nil(t, result.Err, i)
+		require.Equal(t, "/item/"+strconv.Itoa(i), string(result.Response.GetBody()))
+	}
+
+	hr, _ := http.NewRequest("GET", target+"/a", nil)
+	hr2, _ := http.NewRequest("GET", "http://127.0.0.1:1/b", nil)
+	_, err = client.Pipeline(context.Background(), []*Request{{RawRequest: hr}, {RawRequest: hr2}}, nil)
+	require.NotNil(t, err)
+}
+
+func TestClient_PipelineProxy(t *testing.T) {
+	target := testhttp.CreatePipelineServer(t, 2)
+	var connected string
+	proxy := newConnectProxy(&connected)
+	defer proxy.Close()
+
+	options := DefaultClientOptions()
+	options.Proxy = proxy.URL
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	var reqs []*Request
+	for i := 0; i < 2; i++ {
+		hr, _ := http.NewRequest("GET", target+"/item/"+strconv.Itoa(i), nil)
+		reqs = append(reqs, &Request{RawRequest: hr})
+	}
+	results, err := client.Pipeline(context.Background(), reqs, DefaultPipelineOptions())
+	require.Nil(t, err)
+	require.Equal(t, strings.TrimPrefix(target, "http://"), connected)
+	for i, result := range results {
+		require.Nil(t, result.Err, i)
+		require.Equal(t, "/item/"+strconv.Itoa(i), string(result.Response.GetBody()))
+	}
+}
+
+func TestClient_PipelineFallback(t *testing.T) {
+	// a server that doesn't pipeline: requests beyond the first are answered
+	// only after the 1s timeout of the batch server
+	target := testhttp.CreatePipelineServer(t, 1)
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+
+	var reqs []*Request
+	for i := 0; i < 5; i++ {
+		hr, _ := http.NewRequest("POST", target+"/item/"+strconv.Itoa(i), nil)
+		req := &Request{RawRequest: hr}
+		req.SetBody([]byte("x"))
+		reqs = append(reqs, req)
+	}
+	// the POSTs sent behind the answered one may have been processed
+	results, err := client.Pipeline(context.Background(), reqs, DefaultPipelineOptions())
+	require.Nil(t, err)
+	require.Nil(t, results[0].Err)
+	notResent := 0
+	for _, result := range results[1:] {
+		if result.Err != nil {
+			require.ErrorIs(t, result.Err, ErrPipelineNotResent)
+			notResent++
+		}
+	}
+	require.Greater(t, notResent, 0)
+
+	options := DefaultPipelineOptions()
+	options.ResendNonIdempotent = true
+	results, err = client.Pipeline(context.Background(), reqs, options)
+	require.Nil(t, err)
+	for i, result := range results {
+		require.Nil(t, result.Err, i)
+		require.Equal(t, "/item/"+strconv.Itoa(i), string(result.Response.GetBody()))
+		require.Equal(t, "1", result.Response.GetHeaders().Get("X-Conn-Request"))
+	}
+}
@@ -0,0 +1,338 @@
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/iami317/shttp/xtls"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Protocols, see ClientOptions.Protocol and Request.SetProtocol
+const (
+	ProtocolHTTP1      = "http/1.1"
+	ProtocolH2         = "h2"          // alpn h2 over tls, falls back to http/1.1, plain http stays on http/1.1
+	ProtocolH2C        = "h2c"         // cleartext h2 with prior knowledge, https urls use ProtocolH2
+	ProtocolH2CUpgrade = "h2c-upgrade" // http/1.1 Upgrade: h2c, the http/1.1 response when the server doesn't switch
+	ProtocolH3         = "h3"          // http/3 over quic, plain http stays on http/1.1
+)
+
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// ErrH2CProxy cleartext h2 dials the target itself, it would bypass the proxy and the scope check of the resolved address
+var ErrH2CProxy = errors.New("h2c can't be used through a proxy")
+
+type protocolContextKey struct{}
+
+func verifyProtocol(protocol string) error {
+	switch protocol {
+	case "", ProtocolHTTP1, ProtocolH2, ProtocolH2C, ProtocolH2CUpgrade, ProtocolH3:
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %s", protocol)
+}
+
+// protocolTransport routes every request to the transport of its protocol,
+// h2c and h3 are unavailable through a proxy, h3 also through a unix socket or
+// a custom dial function
+type protocolTransport struct {
+	protocol   string
+	proxy      bool
+	customDial bool // a unix socket or a custom dial function replaces the tcp dialer
+	http1      *http.Transport
+	h2         *http.Transport
+	h2c        *http2.Transport
+	upgrade    *h2cUpgradeTransport
+	h3         *http3.Transport
+	altSvc     *altSvcCache
+}
+
+// newProtocolTransport base is the http/1.1 transport, the others are derived from it,
+// s is checked before quic dials since base.DialContext isn't used for udp
+func newProtocolTransport(options *ClientOptions, base *http.Transport, s *scope, handshaker *xtls.Handshaker) (*protocolTransport, error) {
+	protocol := options.Protocol
+	if protocol == "" && options.EnableHTTP2 {
+		protocol = ProtocolH2
+	}
+	if err := verifyProtocol(protocol); err != nil {
+		return nil, err
+	}
+
+	t := &protocolTransport{protocol: protocol, http1: base, proxy: base.Proxy != nil, customDial: options.UnixSocket != "" || options.DialContext != nil}
+	t.h2 = base.Clone()
+	// an empty TLSNextProto keeps net/http from negotiating h2 on its own
+	base.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if err := http2.ConfigureTransport(t.h2); err != nil {
+		return nil, err
+	}
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	if dialTLS := handshaker.DialTLS(dial, t.h2.TLSClientConfig.NextProtos); dialTLS != nil {
+		t.h2.DialTLSContext = dialTLS
+	}
+	t.h2c = &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+	t.upgrade = &h2cUpgradeTransport{dial: dial, maxBodySize: options.MaxRespBodySize}
+	// quic has its own sockets, it can't go through a proxy, a unix socket or a custom dial function
+	if base.Proxy == nil && !t.customDial {
+		if options.AltSvc {
+			t.altSvc = newAltSvcCache()
+		}
+		sources, err := newSourcePool(options)
+		if err != nil {
+			return nil, err
+		}
+		t.h3 = newH3Transport(options, base.TLSClientConfig, handshaker, s, sources, t.altSvc)
+	}
+	return t, nil
+}
+
+func (t *protocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	protocol := t.protocol
+	pinned := false
+	if p, ok := req.Context().Value(protocolContextKey{}).(string); ok && p != "" {
+		protocol, pinned = p, true
+	}
+	plain := req.URL.Scheme == "http"
+	// Alt-Svc only upgrades requests that didn't ask for a protocol themselves
+	if t.altSvc != nil && !plain && !pinned && protocol != ProtocolH3 {
+		if protocol == ProtocolH2 || protocol == ProtocolH2C || protocol == ProtocolH2CUpgrade {
+			return t.roundTripAltSvc(req, t.h2)
+		}
+		return t.roundTripAltSvc(req, t.http1)
+	}
+	switch protocol {
+	case "", ProtocolHTTP1:
+		return t.http1.RoundTrip(req)
+	case ProtocolH2:
+		return t.h2.RoundTrip(req)
+	case ProtocolH2C:
+		if plain && t.proxy {
+			return nil, ErrH2CProxy
+		}
+		if plain {
+			return t.h2c.RoundTrip(req)
+		}
+		return t.h2.RoundTrip(req)
+	case ProtocolH2CUpgrade:
+		if plain && t.proxy {
+			return nil, ErrH2CProxy
+		}
+		if plain {
+			return t.upgrade.RoundTrip(req)
+		}
+		return t.h2.RoundTrip(req)
+	case ProtocolH3:
+		if plain {
+			return t.http1.RoundTrip(req)
+		}
+		if t.h3 == nil && t.customDial {
+			return nil, ErrH3Dialer
+		}
+		if t.h3 == nil {
+			return nil, ErrH3Proxy
+		}
+		return t.h3.RoundTrip(req)
+	}
+	return nil, verifyProtocol(protocol)
+}
+
+func (t *protocolTransport) CloseIdleConnections() {
+	t.http1.CloseIdleConnections()
+	t.h2.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+	if t.h3 != nil {
+		t.h3.CloseIdleConnections()
+	}
+}
+
+// h2cUpgradeTransport one connection per request, the response to the upgrade
+// request arrives on stream 1 and is read with a bare framer since
+// http2.Transport can't adopt an upgraded connection
+type h2cUpgradeTransport struct {
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	maxBodySize int64
+}
+
+func (t *h2cUpgradeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	conn, err := t.dial(req.Context(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// a cancelled request unblocks the reads below
+	stop := context.AfterFunc(req.Context(), func() { conn.Close() })
+
+	resp, upgraded, err := t.roundTrip(conn, req)
+	if err != nil || upgraded {
+		stop()
+		conn.Close()
+		return resp, err
+	}
+	resp.Body = &closeConnBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+// roundTrip upgraded reports whether resp came over http/2, the connection is done then
+func (t *h2cUpgradeTransport) roundTrip(conn net.Conn, req *http.Request) (*http.Response, bool, error) {
+	upgradeReq := req.Clone(req.Context())
+	upgradeReq.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	upgradeReq.Header.Set("Upgrade", "h2c")
+	upgradeReq.Header.Set("HTTP2-Settings", base64.RawURLEncoding.EncodeToString(nil))
+	if err := upgradeReq.Write(conn); err != nil {
+		return nil, false, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// not upgraded, the http/1.1 response is the answer
+		return resp, false, nil
+	}
+	resp.Body.Close()
+	resp, err = t.readStream1(conn, br, req)
+	return resp, true, err
+}
+
+// readStream1 speaks just enough http/2 to receive the response on stream 1
+func (t *h2cUpgradeTransport) readStream1(conn net.Conn, br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	if _, err := io.WriteString(conn, http2ClientPreface); err != nil {
+		return nil, err
+	}
+	framer := http2.NewFramer(conn, br)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := framer.WriteSettings(); err != nil {
+		return nil, err
+	}
+
+	var (
+		resp *http.Response
+		body bytes.Buffer
+	)
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("h2c upgrade: %w", err)
+		}
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err = framer.WriteSettingsAck(); err != nil {
+					return nil, err
+				}
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				if err = framer.WritePing(true, f.Data); err != nil {
+					return nil, err
+				}
+			}
+		case *http2.GoAwayFrame:
+			if f.LastStreamID < 1 {
+				return nil, fmt.Errorf("h2c upgrade: server sent GOAWAY %v", f.ErrCode)
+			}
+		case *http2.RSTStreamFrame:
+			if f.StreamID == 1 {
+				return nil, fmt.Errorf("h2c upgrade: stream reset %v", f.ErrCode)
+			}
+		case *http2.MetaHeadersFrame:
+			if f.StreamID != 1 {
+				continue
+			}
+			if resp == nil {
+				if resp, err = newH2CResponse(f, req); err != nil {
+					return nil, err
+				}
+			} else {
+				resp.Trailer = make(http.Header)
+				for _, field := range f.RegularFields() {
+					resp.Trailer.Add(http.CanonicalHeaderKey(field.Name), field.Value)
+				}
+			}
+			if f.StreamEnded() {
+				return finishH2CResponse(resp, &body), nil
+			}
+		case *http2.DataFrame:
+			if f.StreamID != 1 || resp == nil {
+				continue
+			}
+			body.Write(f.Data())
+			if t.maxBodySize > 0 && int64(body.Len()) >= t.maxBodySize {
+				return finishH2CResponse(resp, &body), nil
+			}
+			if n := uint32(len(f.Data())); n > 0 {
+				// keep the server sending, both windows start at 64k
+				if err = framer.WriteWindowUpdate(0, n); err != nil {
+					return nil, err
+				}
+				if err = framer.WriteWindowUpdate(1, n); err != nil {
+					return nil, err
+				}
+			}
+			if f.StreamEnded() {
+				return finishH2CResponse(resp, &body), nil
+			}
+		}
+	}
+}
+
+func newH2CResponse(f *http2.MetaHeadersFrame, req *http.Request) (*http.Response, error) {
+	status, err := strconv.Atoi(f.PseudoValue("status"))
+	if err != nil {
+		return nil, fmt.Errorf("h2c upgrade: invalid status %q", f.PseudoValue("status"))
+	}
+	resp := &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        make(http.Header),
+		Request:       req,
+		ContentLength: -1,
+	}
+	for _, field := range f.RegularFields() {
+		resp.Header.Add(http.CanonicalHeaderKey(field.Name), field.Value)
+	}
+	return resp, nil
+}
+
+func finishH2CResponse(resp *http.Response, body *bytes.Buffer) *http.Response {
+	resp.ContentLength = int64(body.Len())
+	resp.Body = io.NopCloser(body)
+	return resp
+}
+
+// closeConnBody closes the single use connection with the body
+type closeConnBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *closeConnBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stop()
+	b.conn.Close()
+	return err
+}
@@ -0,0 +1,183 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_Protocol(t *testing.T) {
+	http1 := testhttp.CreateHTTP1Server(t)
+	defer http1.Close()
+	h2 := testhttp.CreateH2Server(t)
+	defer h2.Close()
+	h2cPrior := testhttp.CreateH2CServer(t)
+	defer h2cPrior.Close()
+	h2cUpgrade := testhttp.CreateH2CUpgradeServer(t)
+	defer h2cUpgrade.Close()
+
+	cases := []struct {
+		protocol string
+		server   *httptest.Server
+		want     string
+		body     string
+	}{
+		{"", h2, "http/1.1", "HTTP/1.1 POST ping"},
+		{ProtocolHTTP1, h2, "http/1.1", "HTTP/1.1 POST ping"},
+		{ProtocolH2, h2, "h2", "HTTP/2.0 POST ping"},
+		{ProtocolH2, http1, "http/1.1", "HTTP/1.1 POST ping"},
+		{ProtocolH2C, h2cPrior, "h2c", "HTTP/2.0 POST ping"},
+		// the upgraded request itself was sent as http/1.1, its response arrives over h2
+		{ProtocolH2CUpgrade, h2cUpgrade, "h2c", "HTTP/1.1 POST ping"},
+		{ProtocolH2CUpgrade, h2cPrior, "http/1.1", "HTTP/1.1 POST ping"},
+	}
+	for _, c := range cases {
+		for _, perRequest := range []bool{false, true} {
+			options := DefaultClientOptions()
+			if !perRequest {
+				options.Protocol = c.protocol
+			}
+			client, err := NewClient(options, nil)
+			require.Nil(t, err)
+
+			hr, _ := http.NewRequest("POST", c.server.URL, bytes.NewReader([]byte("ping")))
+			req := &Request{RawRequest: hr}
+			if perRequest {
+				req.SetProtocol(c.protocol)
+			}
+			resp, err := client.Do(context.Background(), req)
+			require.Nil(t, err, c.protocol)
+			require.Equal(t, c.want, resp.GetProtocol(), c.protocol)
+			require.Equal(t, c.body, string(resp.GetBody()), c.protocol)
+		}
+	}
+
+	// larger than the initial flow control window
+	options := DefaultClientOptions()
+	options.Protocol = ProtocolH2CUpgrade
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", h2cUpgrade.URL+"?size=200000", nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, ProtocolH2C, resp.GetProtocol())
+	require.Len(t, resp.GetBody(), 200000)
+
+	options.Protocol = "spdy"
+	_, err = NewClient(options, nil)
+	require.NotNil(t, err)
+}
+
+func TestClient_H3(t *testing.T) {
+	ts := testhttp.CreateH3Server(t)
+	defer ts.Close()
+
+	post := func(client *Client, protocol string) *Response {
+		hr, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("ping")))
+		req := &Request{RawRequest: hr}
+		req.SetProtocol(protocol)
+		resp, err := client.Do(context.Background(), req)
+		require.Nil(t, err)
+		return resp
+	}
+
+	options := DefaultClientOptions()
+	options.Protocol = ProtocolH3
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	resp := post(client, "")
+	require.Equal(t, ProtocolH3, resp.GetProtocol())
+	require.Equal(t, "HTTP/3.0 POST ping", string(resp.GetBody()))
+	require.NotNil(t, resp.GetTLSInfo())
+
+	// discovered from the Alt-Svc of the first response
+	options = DefaultClientOptions()
+	options.AltSvc = true
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	require.Equal(t, "HTTP/1.1 POST ping", string(post(client, "").GetBody()))
+	require.Equal(t, "HTTP/3.0 POST ping", string(post(client, "").GetBody()))
+	// a request asking for a protocol isn't upgraded
+	require.Equal(t, "HTTP/1.1 POST ping", string(post(client, ProtocolHTTP1).GetBody()))
+
+	options = DefaultClientOptions()
+	options.Protocol = ProtocolH3
+	options.Proxy = "http://127.0.0.1:1"
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.ErrorIs(t, err, ErrH3Proxy)
+}
+
+func TestClient_H2CProxy(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}), &http2.Server{}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	options := DefaultClientOptions()
+	options.Proxy = "http://127.0.0.1:1"
+	// the name passes, the address it resolves to is denied
+	options.Scope = &ScopeOptions{DenyCIDRs: []string{"127.0.0.0/8"}}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for _, protocol := range []string{ProtocolH2C, ProtocolH2CUpgrade} {
+		hr, _ := http.NewRequest("GET", "http://localhost:"+port, nil)
+		_, err = client.Do(context.Background(), (&Request{RawRequest: hr}).SetProtocol(protocol))
+		require.ErrorIs(t, err, ErrH2CProxy, protocol)
+	}
+	require.Equal(t, int32(0), hits.Load())
+}
+
+func TestClient_AltSvcFallback(t *testing.T) {
+	// nothing listens on the advertised udp port
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", `h3=":1"`)
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.AltSvc = true
+	options.TLSHandshakeTimeout = 1
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		hr, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("ping")))
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, "HTTP/1.1", string(resp.GetBody()))
+	}
+}
+
+func TestParseAltSvc(t *testing.T) {
+	authority, maxAge, cleared := parseAltSvc([]string{`h3-29=":443"; ma=60, h3="alt.example.com:8443"; ma=120`})
+	require.Equal(t, "alt.example.com:8443", authority)
+	require.Equal(t, 120*time.Second, maxAge)
+	require.False(t, cleared)
+
+	authority, maxAge, _ = parseAltSvc([]string{`h2=":443"`, `h3=":443"`})
+	require.Equal(t, ":443", authority)
+	require.Equal(t, defaultAltSvcMaxAge, maxAge)
+
+	_, _, cleared = parseAltSvc([]string{"clear"})
+	require.True(t, cleared)
+
+	cache := newAltSvcCache()
+	cache.update("example.com:443", []string{`h3=":4433"`})
+	require.Equal(t, "example.com:4433", cache.lookup("example.com:443"))
+	cache.update("example.com:443", []string{"clear"})
+	require.Equal(t, "", cache.lookup("example.com:443"))
+}
@@ -0,0 +1,378 @@
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http2"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Race modes, see RaceOptions.Mode
+const (
+	RaceLastByte     = "last-byte"     // http/1.1, one connection per request, the last byte of every request is held back
+	RaceSinglePacket = "single-packet" // h2, every stream of one connection is completed by a single tcp packet
+)
+
+// RaceOptions synchronized sending of requests, connections go through the proxy when there is one
+type RaceOptions struct {
+	Mode    string `json:"mode" yaml:"mode" #:"同步方式, 可选: last-byte(http/1.1 多连接), single-packet(h2 单连接单包), 默认 last-byte"`
+	Timeout int    `json:"timeout" yaml:"timeout" #:"等待响应的超时时间(秒), 0 则使用 read_timeout"`
+}
+
+// RaceResult Err is set instead of Response when the request failed
+type RaceResult struct {
+	Request    *Request
+	Response   *Response
+	Err        error
+	SentAt     time.Time // the held back bytes were released
+	ReceivedAt time.Time // the response headers arrived
+}
+
+// Latency from the release to the response headers
+func (r *RaceResult) Latency() time.Duration {
+	if r.ReceivedAt.IsZero() {
+		return 0
+	}
+	return r.ReceivedAt.Sub(r.SentAt)
+}
+
+// Race sends requests so that they arrive together, for race condition tests
+// such as coupon reuse or double spend. Requests run through the request
+// middlewares, the limiter and the cookie jar but are neither retried nor
+// redirected, one result per request is returned in order.
+func (c *Client) Race(ctx context.Context, reqs []*Request, options *RaceOptions) ([]*RaceResult, error) {
+	if len(reqs) == 0 {
+		return nil, errors.New("race: no requests")
+	}
+	if options == nil {
+		options = &RaceOptions{}
+	}
+	timeout := time.Duration(options.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(c.ClientOptions.ReadTimeout) * time.Second
+	}
+
+	results := make([]*RaceResult, len(reqs))
+	for i, req := range reqs {
+		results[i] = &RaceResult{Request: req}
+		if err := c.prepareDirect(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	switch options.Mode {
+	case "", RaceLastByte:
+		c.raceLastByte(ctx, results, timeout)
+	case RaceSinglePacket:
+		if err := c.raceSinglePacket(ctx, results, timeout); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("race: unknown mode %s", options.Mode)
+	}
+
+	for _, result := range results {
+		if result.Response == nil {
+			continue
+		}
+		if err := c.finishDirect(result.Response); err != nil {
+			result.Response, result.Err = nil, err
+		}
+	}
+	return results, nil
+}
+
+// prepareDirect the middlewares, limiter and cookies Client.Do would apply to a
+// request sent on a connection of our own, the body is buffered
+func (c *Client) prepareDirect(ctx context.Context, req *Request) error {
+	req.SetContext(ctx)
+	req.attempt = 1
+	if err := c.ClientOptions.Limiter.Wait(ctx); err != nil {
+		return err
+	}
+	for _, f := range c.extraBeforeRequest {
+		if err := f(req, c); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.defaultBeforeRequest {
+		if err := f(req, c); err != nil {
+			return err
+		}
+	}
+	if c.HTTPClient.Jar != nil {
+		for _, cookie := range c.HTTPClient.Jar.Cookies(req.RawRequest.URL) {
+			req.RawRequest.AddCookie(cookie)
+		}
+	}
+	_, err := req.GetBody()
+	return err
+}
+
+// finishDirect tls verification and response middlewares of a response read
+// from a connection of our own
+func (c *Client) finishDirect(response *Response) error {
+	// handshaked by handshakeTLS, enforced checks already ran
+	if c.tlsVerifier != nil && c.tlsVerifier.ReportOnly() && response.tlsInfo != nil {
+		response.tlsVerify = c.tlsVerifier.Verify(response.Request.RawRequest.URL.Hostname(), response.tlsInfo.State)
+	}
+	for _, f := range c.afterResponse {
+		if err := f(response, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raceLastByte every connection is dialed and sent all but the last byte
+// before the last bytes are written at once
+func (c *Client) raceLastByte(ctx context.Context, results []*RaceResult, timeout time.Duration) {
+	var (
+		prepared sync.WaitGroup
+		done     sync.WaitGroup
+		release  = make(chan struct{})
+	)
+	prepared.Add(len(results))
+	done.Add(len(results))
+	for _, result := range results {
+		go func(result *RaceResult) {
+			defer done.Done()
+			conn, br, raw, err := c.presendRace(ctx, result.Request)
+			prepared.Done()
+			if err != nil {
+				result.Err = err
+				return
+			}
+			defer conn.Close()
+
+			<-release
+			if _, err = conn.Write(raw[len(raw)-1:]); err != nil {
+				result.Err = err
+				return
+			}
+			result.SentAt = time.Now()
+			result.Request.sendAt = result.SentAt
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+			resp, err := http.ReadResponse(br, result.Request.RawRequest)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.ReceivedAt = time.Now()
+			result.Response, result.Err = c.newDirectResponse(result.Request, resp, conn, result.ReceivedAt)
+		}(result)
+	}
+	prepared.Wait()
+	close(release)
+	done.Wait()
+}
+
+// newDirectResponse the body is buffered up to MaxRespBodySize and the rest is
+// discarded, the connection stays usable for the next response
+func (c *Client) newDirectResponse(req *Request, resp *http.Response, conn net.Conn, receivedAt time.Time) (*Response, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.ClientOptions.MaxRespBodySize))
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.TLS = connState(conn)
+	req.localAddr, req.remoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	response := &Response{Request: req, RawResponse: resp, receivedAt: receivedAt}
+	response.setTLSInfo()
+	return response, nil
+}
+
+// presendRace dials the request's host and writes the request except its last byte
+func (c *Client) presendRace(ctx context.Context, req *Request) (net.Conn, *bufio.Reader, []byte, error) {
+	raw, err := serializeRequest(ctx, req, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	conn, err := c.dialURL(ctx, req.RawRequest.URL, []string{"http/1.1"})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err = conn.Write(raw[:len(raw)-1]); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return conn, bufio.NewReader(conn), raw, nil
+}
+
+// serializeRequest wire bytes of the prepared request, kept as its raw request
+func serializeRequest(ctx context.Context, req *Request, closeConn bool) ([]byte, error) {
+	rawReq := req.RawRequest.Clone(ctx)
+	rawReq.Close = closeConn
+	raw, err := writeRequest(ctx, rawReq, req.Body, req.chunked)
+	if err != nil {
+		return nil, err
+	}
+	req.raw = raw
+	return req.raw, nil
+}
+
+// writeRequest wire bytes of rawReq with body, or with the chunked body when there is one
+func writeRequest(ctx context.Context, rawReq *http.Request, body []byte, chunked *ChunkedBody) ([]byte, error) {
+	if chunked != nil {
+		return serializeChunked(ctx, rawReq, chunked)
+	}
+	rawReq.Body = nil
+	if len(body) > 0 {
+		rawReq.Body = io.NopCloser(bytes.NewReader(body))
+		rawReq.ContentLength = int64(len(body))
+	}
+	var buf bytes.Buffer
+	if err := rawReq.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// raceSinglePacket one h2 connection, every stream is opened and sent all but
+// the last byte of its body, a single write then ends all streams
+func (c *Client) raceSinglePacket(ctx context.Context, results []*RaceResult, timeout time.Duration) error {
+	u := results[0].Request.RawRequest.URL
+	if u.Scheme != "https" {
+		return errors.New("race: single-packet needs https")
+	}
+	for _, result := range results[1:] {
+		if other := result.Request.RawRequest.URL; other.Scheme != u.Scheme || other.Host != u.Host {
+			return errors.New("race: single-packet requests must share one origin")
+		}
+	}
+	conn, err := c.DialH2(ctx, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ids := make([]uint32, len(results))
+	for i, result := range results {
+		ids[i] = conn.NextStreamID()
+		req := result.Request
+		if err = conn.WriteHeaders(ids[i], raceH2Headers(req.RawRequest, len(req.Body)), false); err != nil {
+			return err
+		}
+		if len(req.Body) > 1 {
+			if err = conn.WriteData(ids[i], req.Body[:len(req.Body)-1], false); err != nil {
+				return err
+			}
+		}
+	}
+	err = conn.WriteBatch(func(framer *http2.Framer) error {
+		for i, result := range results {
+			var last []byte
+			if body := result.Request.Body; len(body) > 0 {
+				last = body[len(body)-1:]
+			}
+			if err := framer.WriteData(ids[i], true, last); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sentAt := time.Now()
+
+	streams, err := conn.ReadResponses(ids, timeout)
+	state := connState(conn.Conn())
+	for i, result := range results {
+		result.SentAt = sentAt
+		result.Request.sendAt = sentAt
+		stream := streams[i]
+		if stream.Reset {
+			result.Err = fmt.Errorf("race: stream %d reset: %v", stream.StreamID, stream.ErrCode)
+			continue
+		}
+		if stream.Headers == nil {
+			result.Err = err
+			if result.Err == nil {
+				result.Err = fmt.Errorf("race: stream %d got no response", stream.StreamID)
+			}
+			continue
+		}
+		resp, respErr := newRaceH2Response(result.Request.RawRequest, stream)
+		if respErr != nil {
+			result.Err = respErr
+			continue
+		}
+		resp.TLS = state
+		result.Request.localAddr, result.Request.remoteAddr = conn.Conn().LocalAddr(), conn.Conn().RemoteAddr()
+		result.ReceivedAt = stream.ReceivedAt
+		result.Response = &Response{Request: result.Request, RawResponse: resp, receivedAt: stream.ReceivedAt}
+		result.Response.setTLSInfo()
+	}
+	return nil
+}
+
+// raceH2Headers request headers as net/http would send them over h2
+func raceH2Headers(req *http.Request, bodyLen int) []H2Header {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := []H2Header{
+		{":method", req.Method},
+		{":authority", host},
+		{":scheme", req.URL.Scheme},
+		{":path", req.URL.RequestURI()},
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		switch name {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "content-length":
+			continue
+		}
+		for _, value := range values {
+			headers = append(headers, H2Header{name, value})
+		}
+	}
+	if bodyLen > 0 {
+		headers = append(headers, H2Header{"content-length", strconv.Itoa(bodyLen)})
+	}
+	return headers
+}
+
+// newRaceH2Response http.Response of a finished stream
+func newRaceH2Response(req *http.Request, stream *H2StreamResponse) (*http.Response, error) {
+	status, err := strconv.Atoi(stream.Status())
+	if err != nil {
+		return nil, fmt.Errorf("race: stream %d invalid status %q", stream.StreamID, stream.Status())
+	}
+	resp := &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        make(http.Header),
+		Trailer:       make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(stream.Body)),
+		ContentLength: int64(len(stream.Body)),
+		Request:       req,
+	}
+	for _, h := range stream.Headers {
+		if !strings.HasPrefix(h.Name, ":") {
+			resp.Header.Add(h.Name, h.Value)
+		}
+	}
+	for _, h := range stream.Trailers {
+		resp.Trailer.Add(h.Name, h.Value)
+	}
+	return resp, nil
+}
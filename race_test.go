@@ -0,0 +1,90 @@
+package shttp
+
+import (
+	"bytes"
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClient_Race(t *testing.T) {
+	for _, mode := range []string{RaceLastByte, RaceSinglePacket} {
+		ts := testhttp.CreateRaceServer(t)
+		client, err := NewDefaultClient(nil)
+		require.Nil(t, err)
+
+		var reqs []*Request
+		for i := 0; i < 5; i++ {
+			hr, _ := http.NewRequest("POST", ts.URL+"/redeem", bytes.NewReader([]byte("coupon=SAVE10")))
+			reqs = append(reqs, &Request{RawRequest: hr})
+		}
+		results, err := client.Race(context.Background(), reqs, &RaceOptions{Mode: mode})
+		require.Nil(t, err, mode)
+		require.Len(t, results, 5)
+
+		proto := "HTTP/1.1"
+		if mode == RaceSinglePacket {
+			proto = "HTTP/2.0"
+		}
+		for i, result := range results {
+			require.Nil(t, result.Err, mode)
+			require.Same(t, reqs[i], result.Request)
+			// every request passed the check before the first one used the coupon
+			require.Equal(t, 200, result.Response.GetStatus(), mode)
+			require.Equal(t, "redeemed "+proto, string(result.Response.GetBody()), mode)
+			require.Greater(t, result.Latency().Milliseconds(), int64(90), mode)
+			latency, err := result.Response.GetLatency()
+			require.Nil(t, err)
+			require.Equal(t, result.Latency(), latency)
+			require.NotNil(t, result.Response.GetTLSInfo())
+		}
+
+		// sequential requests only redeem once more
+		hr, _ := http.NewRequest("POST", ts.URL+"/redeem", bytes.NewReader([]byte("coupon=SAVE10")))
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, 409, resp.GetStatus())
+		hr, _ = http.NewRequest("GET", ts.URL+"/redeemed", nil)
+		resp, err = client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Equal(t, "5", string(resp.GetBody()))
+		ts.Close()
+	}
+
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	hr1, _ := http.NewRequest("GET", "https://127.0.0.1:1/", nil)
+	hr2, _ := http.NewRequest("GET", "https://127.0.0.2:1/", nil)
+	_, err = client.Race(context.Background(), []*Request{{RawRequest: hr1}, {RawRequest: hr2}}, &RaceOptions{Mode: RaceSinglePacket})
+	require.True(t, strings.Contains(err.Error(), "one origin"))
+}
+
+func TestClient_RaceProxy(t *testing.T) {
+	ts := testhttp.CreateRaceServer(t)
+	defer ts.Close()
+	var connected string
+	proxy := newConnectProxy(&connected)
+	defer proxy.Close()
+
+	options := DefaultClientOptions()
+	options.Proxy = proxy.URL
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	var reqs []*Request
+	for i := 0; i < 3; i++ {
+		hr, _ := http.NewRequest("POST", ts.URL+"/redeem", bytes.NewReader([]byte("coupon=SAVE10")))
+		reqs = append(reqs, &Request{RawRequest: hr})
+	}
+	// one connection, tunneled to the target
+	results, err := client.Race(context.Background(), reqs, &RaceOptions{Mode: RaceSinglePacket})
+	require.Nil(t, err)
+	require.Equal(t, strings.TrimPrefix(ts.URL, "https://"), connected)
+	for _, result := range results {
+		require.Nil(t, result.Err)
+		require.Equal(t, "redeemed HTTP/2.0", string(result.Response.GetBody()))
+	}
+}
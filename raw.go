@@ -0,0 +1,320 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/net/proxy"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// How a raw exchange ended, see RawResult.End
+const (
+	RawEndClosed  = "closed"   // the peer closed the connection
+	RawEndIdle    = "idle"     // nothing arrived within the read timeout
+	RawEndTimeout = "timeout"  // the total timeout expired
+	RawEndMaxSize = "max-size" // max size bytes were read
+	RawEndError   = "error"    // reading failed, see RawResult.Err
+)
+
+// RawOptions reading of a raw exchange
+type RawOptions struct {
+	ReadTimeout int   `json:"read_timeout" yaml:"read_timeout" #:"单次读取的超时时间(秒), 超时视为对端已发送完毕, 0 则使用 client 的 read_timeout"`
+	Timeout     int   `json:"timeout" yaml:"timeout" #:"整个交互的超时时间(秒), 包括建立连接, 0 不限制"`
+	MaxSize     int64 `json:"max_size" yaml:"max_size" #:"最多读取的字节数, 0 则使用 max_resp_body_size"`
+}
+
+// RawResult bytes received and timing of a raw exchange
+type RawResult struct {
+	Data       []byte
+	End        string
+	Err        error // read error, Data holds what arrived before
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	TLS        *tls.ConnectionState
+
+	StartAt     time.Time
+	ConnectedAt time.Time // tcp connected, through the proxy, and tls handshaked
+	SentAt      time.Time
+	FirstByteAt time.Time // zero when nothing arrived
+	DoneAt      time.Time
+}
+
+// FirstByteLatency from the end of the write to the first byte received
+func (r *RawResult) FirstByteLatency() time.Duration {
+	if r.FirstByteAt.IsZero() {
+		return 0
+	}
+	return r.FirstByteAt.Sub(r.SentAt)
+}
+
+// Duration of the whole exchange, connecting included
+func (r *RawResult) Duration() time.Duration {
+	return r.DoneAt.Sub(r.StartAt)
+}
+
+// SendRaw connects to target, writes data and reads until the peer closes, goes
+// idle for the read timeout, the total timeout expires or max size bytes
+// arrived. target is tcp://host:port or http://host[:port] for plain tcp,
+// tls://host:port or https://host[:port] for tls. The client's dialer, proxy,
+// source addresses, tls settings, scope and limiter apply.
+func (c *Client) SendRaw(ctx context.Context, target string, data []byte, options *RawOptions) (*RawResult, error) {
+	if options == nil {
+		options = &RawOptions{}
+	}
+	readTimeout := time.Duration(options.ReadTimeout) * time.Second
+	if readTimeout <= 0 {
+		readTimeout = time.Duration(c.ClientOptions.ReadTimeout) * time.Second
+	}
+	maxSize := options.MaxSize
+	if maxSize <= 0 {
+		maxSize = c.ClientOptions.MaxRespBodySize
+	}
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(options.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	result := &RawResult{StartAt: time.Now()}
+	conn, err := c.DialRaw(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	result.ConnectedAt = time.Now()
+	result.LocalAddr, result.RemoteAddr = conn.LocalAddr(), conn.RemoteAddr()
+	result.TLS = connState(conn)
+	deadline, hasDeadline := ctx.Deadline()
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if hasDeadline {
+		_ = conn.SetWriteDeadline(deadline)
+	}
+	if _, err = conn.Write(data); err != nil {
+		return nil, err
+	}
+	result.SentAt = time.Now()
+
+	// the read deadline is clamped to the total one and may expire before the
+	// timer of ctx fired, the clock tells which one ended the exchange
+	timedOut := func() bool {
+		return ctx.Err() != nil || (hasDeadline && !time.Now().Before(deadline))
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		if timedOut() {
+			result.End = RawEndTimeout
+			break
+		}
+		readDeadline := time.Now().Add(readTimeout)
+		if hasDeadline && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		_ = conn.SetReadDeadline(readDeadline)
+		n, err := conn.Read(buf[:min(int64(len(buf)), maxSize-int64(len(result.Data)))])
+		if n > 0 {
+			if result.FirstByteAt.IsZero() {
+				result.FirstByteAt = time.Now()
+			}
+			result.Data = append(result.Data, buf[:n]...)
+		}
+		switch {
+		case int64(len(result.Data)) >= maxSize:
+			result.End = RawEndMaxSize
+		case err == nil:
+			continue
+		case errors.Is(err, io.EOF):
+			result.End = RawEndClosed
+		case timedOut():
+			result.End = RawEndTimeout
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			result.End = RawEndIdle
+		default:
+			result.End, result.Err = RawEndError, err
+		}
+		break
+	}
+	result.DoneAt = time.Now()
+	return result, nil
+}
+
+// DialRaw connection to target of SendRaw for exchanges it doesn't cover, after
+// the scope check and the limiter
+func (c *Client) DialRaw(ctx context.Context, target string) (net.Conn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	var secure bool
+	switch u.Scheme {
+	case "tcp", "http":
+	case "tls", "https":
+		secure = true
+	default:
+		return nil, fmt.Errorf("raw: unsupported scheme %q", u.Scheme)
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		default:
+			return nil, fmt.Errorf("raw: %s has no port", target)
+		}
+	}
+	if err = c.scope.checkURL(u); err != nil {
+		return nil, err
+	}
+	if err = c.ClientOptions.Limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.dialTarget(ctx, u.Hostname(), port, secure, nil)
+}
+
+// dialTarget connection to host:port through the proxy when there is one, tls
+// handshaked offering nextProtos when secure
+func (c *Client) dialTarget(ctx context.Context, host, port string, secure bool, nextProtos []string) (net.Conn, error) {
+	return c.dialTargetVia(ctx, nil, host, port, secure, nextProtos)
+}
+
+// dialTargetVia dialTarget with dial connecting to the target or the proxy,
+// the client's dialer when nil
+func (c *Client) dialTargetVia(ctx context.Context, dial DialFunc, host, port string, secure bool, nextProtos []string) (net.Conn, error) {
+	addr := net.JoinHostPort(host, port)
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch {
+	case c.ClientOptions.Proxy != "":
+		conn, err = c.dialProxy(ctx, dial, addr)
+	case dial != nil:
+		conn, err = dial(ctx, "tcp", addr)
+	default:
+		conn, err = c.dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil || !secure {
+		return conn, err
+	}
+	return c.handshakeTLS(ctx, conn, host, nextProtos)
+}
+
+// dialProxy tunnel to addr through the http(s) or socks5 proxy of the client
+func (c *Client) dialProxy(ctx context.Context, dial DialFunc, addr string) (net.Conn, error) {
+	proxyURL, err := url.Parse(c.ClientOptions.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	// the scope can't check the proxy's address
+	d, err := newDialer(c.ClientOptions, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dial != nil {
+		d.custom = dial
+	}
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		socks, err := proxy.FromURL(proxyURL, forwardDialer(d.DialContext))
+		if err != nil {
+			return nil, err
+		}
+		if cd, ok := socks.(proxy.ContextDialer); ok {
+			return cd.DialContext(ctx, "tcp", addr)
+		}
+		return socks.Dial("tcp", addr)
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("raw: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		if conn, err = c.handshakeTLS(ctx, conn, proxyURL.Hostname(), nil); err != nil {
+			return nil, err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err = connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the body of a successful CONNECT is the tunnel, it is not drained
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("raw: proxy CONNECT %s: %s", addr, resp.Status)
+	}
+	if ctx.Err() != nil {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// forwardDialer DialFunc as the forward dialer of a socks proxy
+type forwardDialer DialFunc
+
+func (f forwardDialer) Dial(network, addr string) (net.Conn, error) {
+	return f(context.Background(), network, addr)
+}
+
+func (f forwardDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// bufferedConn the target already sent bytes together with the proxy's answer
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
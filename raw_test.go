@@ -0,0 +1,132 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"github.com/iami317/shttp/testutils/tcp"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_SendRaw(t *testing.T) {
+	// a redis that answers PING and keeps the connection open otherwise
+	ts := tcp.NewTCPServer(func(conn net.Conn) {
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch strings.TrimSpace(line) {
+		case "PING":
+			_, _ = conn.Write([]byte("+PONG\r\n"))
+		case "BIG":
+			_, _ = conn.Write([]byte(strings.Repeat("x", 100)))
+			time.Sleep(time.Second)
+		default:
+			_, _ = conn.Write([]byte("-ERR\r\n"))
+			time.Sleep(3 * time.Second)
+		}
+	})
+	defer ts.Close()
+
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	result, err := client.SendRaw(context.Background(), "tcp://"+ts.URL, []byte("PING\r\n"), nil)
+	require.Nil(t, err)
+	require.Equal(t, "+PONG\r\n", string(result.Data))
+	require.Equal(t, RawEndClosed, result.End)
+	require.False(t, result.FirstByteAt.IsZero())
+	require.True(t, result.FirstByteLatency() >= 0)
+	require.True(t, result.Duration() >= result.FirstByteLatency())
+
+	result, err = client.SendRaw(context.Background(), "tcp://"+ts.URL, []byte("BIG\r\n"), &RawOptions{MaxSize: 10})
+	require.Nil(t, err)
+	require.Equal(t, RawEndMaxSize, result.End)
+	require.Len(t, result.Data, 10)
+
+	start := time.Now()
+	result, err = client.SendRaw(context.Background(), "tcp://"+ts.URL, []byte("GET\r\n"), &RawOptions{ReadTimeout: 1})
+	require.Nil(t, err)
+	require.Equal(t, RawEndIdle, result.End)
+	require.Equal(t, "-ERR\r\n", string(result.Data))
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	result, err = client.SendRaw(context.Background(), "tcp://"+ts.URL, []byte("GET\r\n"), &RawOptions{ReadTimeout: 5, Timeout: 1})
+	require.Nil(t, err)
+	require.Equal(t, RawEndTimeout, result.End)
+
+	_, err = client.SendRaw(context.Background(), "udp://"+ts.URL, nil, nil)
+	require.NotNil(t, err)
+	_, err = client.SendRaw(context.Background(), "tcp://127.0.0.1", nil, nil)
+	require.NotNil(t, err)
+}
+
+func TestClient_SendRawTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("raw"))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	raw := "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"
+	result, err := client.SendRaw(context.Background(), ts.URL, []byte(raw), nil)
+	require.Nil(t, err)
+	require.NotNil(t, result.TLS)
+	require.True(t, strings.HasPrefix(string(result.Data), "HTTP/1.1 200 OK\r\n"))
+	require.True(t, strings.HasSuffix(string(result.Data), "\r\n\r\nraw"))
+}
+
+func TestClient_SendRawProxy(t *testing.T) {
+	ts := tcp.NewTCPServer(func(conn net.Conn) {
+		defer conn.Close()
+		_, _ = io.Copy(conn, io.LimitReader(conn, 4))
+	})
+	defer ts.Close()
+
+	var connected string
+	proxy := newConnectProxy(&connected)
+	defer proxy.Close()
+
+	options := DefaultClientOptions()
+	options.Proxy = proxy.URL
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	result, err := client.SendRaw(context.Background(), "tcp://"+ts.URL, []byte("echo"), nil)
+	require.Nil(t, err)
+	require.Equal(t, ts.URL, connected)
+	require.Equal(t, "echo", string(result.Data))
+	require.Equal(t, RawEndClosed, result.End)
+}
+
+// newConnectProxy http proxy tunneling CONNECT requests, the last target is stored in connected
+func newConnectProxy(connected *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		*connected = r.Host
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = brw.Flush()
+		go func() { _, _ = io.Copy(target, conn) }()
+		_, _ = io.Copy(conn, target)
+	}))
+}
@@ -0,0 +1,276 @@
+package shttp
+
+import (
+	"context"
+	"golang.org/x/net/publicsuffix"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// RedirectPolicySameHost only follow redirects to the same host name
+	RedirectPolicySameHost = "same-host"
+	// RedirectPolicySameDomain only follow redirects within the same registrable domain (eTLD+1)
+	RedirectPolicySameDomain = "same-domain"
+	// RedirectPolicyNoDowngrade never follow https -> http
+	RedirectPolicyNoDowngrade = "no-downgrade"
+)
+
+const (
+	// RedirectTypeHTTP 3xx + Location
+	RedirectTypeHTTP = "http"
+	// RedirectTypeMetaRefresh <meta http-equiv="refresh"> in the body
+	RedirectTypeMetaRefresh = "meta-refresh"
+	// RedirectTypeJavaScript window.location / location.replace() in the body
+	RedirectTypeJavaScript = "javascript"
+)
+
+// RedirectCallback same contract as http.Client.CheckRedirect, return
+// http.ErrUseLastResponse to stop following and keep the redirect response
+type RedirectCallback func(req *http.Request, via []*http.Request) error
+
+// RedirectHop one followed redirect
+type RedirectHop struct {
+	Type       string
+	Request    *http.Request
+	StatusCode int
+	Header     http.Header
+	Location   string
+	SendAt     time.Time
+	ReceivedAt time.Time
+}
+
+// Duration time spent on the hop
+func (h *RedirectHop) Duration() time.Duration {
+	return h.ReceivedAt.Sub(h.SendAt)
+}
+
+var (
+	metaRefreshRegex    = regexp.MustCompile(`(?is)<meta\s[^>]*http-equiv\s*=\s*["']?refresh["']?[^>]*>`)
+	metaContentRegex    = regexp.MustCompile(`(?is)\scontent\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	refreshURLRegex     = regexp.MustCompile(`(?is)^\s*\d*(?:\.\d*)?\s*[;,]?\s*(?:url\s*=\s*)?["']?([^"']*)["']?\s*$`)
+	jsLocationRegex     = regexp.MustCompile(`(?i)(?:^|[^\w$.])(?:(?:window|document|top|self|parent)\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']`)
+	jsLocationFuncRegex = regexp.MustCompile(`(?i)(?:^|[^\w$.])(?:(?:window|document|top|self|parent)\.)?location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+)
+
+type requestContextKey struct{}
+
+func (r *Request) resetRedirects() {
+	r.redirects = nil
+	r.hopStart = r.sendAt
+	r.hopHost.Store(r.RawRequest.URL.Hostname())
+}
+
+// addRedirectHop next is the request about to be sent for the hop
+func (r *Request) addRedirectHop(hop *RedirectHop, next *http.Request) {
+	now := time.Now()
+	hop.SendAt = r.hopStart
+	hop.ReceivedAt = now
+	r.hopStart = now
+	r.redirects = append(r.redirects, hop)
+	r.hopHost.Store(next.URL.Hostname())
+}
+
+// currentHost host name of the hop in flight
+func (r *Request) currentHost() string {
+	if host, ok := r.hopHost.Load().(string); ok {
+		return host
+	}
+	return r.GetHostName()
+}
+
+type checkRedirectFunc func(req *http.Request, via []*http.Request) error
+
+func makeCheckRedirectFunc(followRedirects bool, options *ClientOptions, s *scope) checkRedirectFunc {
+	return func(req *http.Request, via []*http.Request) error {
+		if !followRedirects {
+			return http.ErrUseLastResponse
+		}
+		if err := s.checkURL(req.URL); err != nil {
+			return err
+		}
+		r, _ := via[0].Context().Value(requestContextKey{}).(*Request)
+		followed := len(via)
+		if r != nil {
+			// hops already followed by the browser-like redirect of an earlier request
+			followed += r.redirectOffset
+		}
+		if followed >= options.MaxRedirect {
+			return http.ErrUseLastResponse
+		}
+		prev := via[len(via)-1]
+		for _, policy := range options.RedirectPolicies {
+			if !allowRedirect(policy, prev, req) {
+				return http.ErrUseLastResponse
+			}
+		}
+		if options.RedirectCallback != nil {
+			if err := options.RedirectCallback(req, via); err != nil {
+				return err
+			}
+		}
+
+		// record the hop that is about to be followed
+		if r != nil && req.Response != nil {
+			r.addRedirectHop(&RedirectHop{
+				Type:       RedirectTypeHTTP,
+				Request:    prev,
+				StatusCode: req.Response.StatusCode,
+				Header:     req.Response.Header,
+				Location:   req.Response.Header.Get("Location"),
+			}, req)
+		}
+		return nil
+	}
+}
+
+func allowRedirect(policy string, prev, next *http.Request) bool {
+	switch policy {
+	case RedirectPolicySameHost:
+		return strings.EqualFold(prev.URL.Hostname(), next.URL.Hostname())
+	case RedirectPolicySameDomain:
+		return strings.EqualFold(registrableDomain(prev.URL.Hostname()), registrableDomain(next.URL.Hostname()))
+	case RedirectPolicyNoDowngrade:
+		return !(strings.EqualFold(prev.URL.Scheme, "https") && strings.EqualFold(next.URL.Scheme, "http"))
+	}
+	return true
+}
+
+// sameOrigin scheme, host and port match, default ports left out or not
+func sameOrigin(a, b *url.URL) bool {
+	return normalizeURL(&url.URL{Scheme: a.Scheme, Host: a.Host}) == normalizeURL(&url.URL{Scheme: b.Scheme, Host: b.Host})
+}
+
+// doBrowserRedirect follows meta refresh and javascript location redirects
+// found in html bodies, sharing MaxRedirect, scope and policies with 3xx.
+func (c *Client) doBrowserRedirect(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil || !c.ClientOptions.BrowserRedirect || !c.followRedirects {
+		return resp, err
+	}
+
+	for {
+		redirectType, location := findBodyRedirect(resp)
+		if location == "" {
+			return resp, nil
+		}
+		prev := resp.RawResponse.Request
+		next, err := prev.URL.Parse(location)
+		if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+			return resp, nil
+		}
+		next.Fragment = ""
+		if normalizeURL(next) == normalizeURL(prev.URL) {
+			// refresh of the page itself
+			return resp, nil
+		}
+		hops := resp.GetRedirectChain()
+		if len(hops)+1 >= c.ClientOptions.MaxRedirect {
+			return resp, nil
+		}
+		if err = c.scope.checkURL(next); err != nil {
+			return nil, err
+		}
+
+		hr, err := http.NewRequest(MethodGet, next.String(), nil)
+		if err != nil {
+			return resp, nil
+		}
+		hr.Header = req.RawRequest.Header.Clone()
+		for _, h := range []string{"Content-Type", "Content-Length", "If-None-Match", "If-Modified-Since"} {
+			hr.Header.Del(h)
+		}
+		// cookies set by the caller only go to the origin they were meant for,
+		// the jar adds its own
+		if !sameOrigin(req.RawRequest.URL, next) {
+			hr.Header.Del("Cookie")
+		}
+		hr.Header.Set("Referer", prev.URL.String())
+		hr.Host = ""
+
+		allowed := true
+		for _, policy := range c.ClientOptions.RedirectPolicies {
+			if !allowRedirect(policy, prev, hr) {
+				allowed = false
+				break
+			}
+		}
+		if !allowed {
+			return resp, nil
+		}
+		if c.ClientOptions.RedirectCallback != nil {
+			via := make([]*http.Request, 0, len(hops)+1)
+			for _, hop := range hops {
+				via = append(via, hop.Request)
+			}
+			via = append(via, prev)
+			if err = c.ClientOptions.RedirectCallback(hr, via); err == http.ErrUseLastResponse {
+				return resp, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		sendAt := req.sendAt
+		if len(hops) > 0 {
+			sendAt = hops[len(hops)-1].ReceivedAt
+		}
+		hops = append(hops, &RedirectHop{
+			Type:       redirectType,
+			Request:    prev,
+			StatusCode: resp.GetStatus(),
+			Header:     resp.GetHeaders(),
+			Location:   location,
+			SendAt:     sendAt,
+			ReceivedAt: resp.receivedAt,
+		})
+
+		nextReq := &Request{RawRequest: hr, trace: req.trace, redirectOffset: len(hops)}
+		nextResp, err := c.do(ctx, nextReq)
+		if err != nil {
+			return nil, err
+		}
+		nextResp.redirects = append(hops, nextResp.redirects...)
+		nextResp.Request = req
+		resp = nextResp
+	}
+}
+
+// findBodyRedirect meta refresh wins over javascript, only 2xx html bodies are inspected
+func findBodyRedirect(resp *Response) (string, string) {
+	if resp.GetStatus() < 200 || resp.GetStatus() >= 300 {
+		return "", ""
+	}
+	contentType := strings.ToLower(resp.GetContentType())
+	if contentType != "" && !strings.Contains(contentType, "html") {
+		return "", ""
+	}
+	body := resp.GetBody()
+	if tag := metaRefreshRegex.Find(body); tag != nil {
+		if m := metaContentRegex.FindSubmatch(tag); m != nil {
+			content := string(m[1]) + string(m[2]) + string(m[3])
+			if u := refreshURLRegex.FindStringSubmatch(html.UnescapeString(content)); u != nil && strings.TrimSpace(u[1]) != "" {
+				return RedirectTypeMetaRefresh, strings.TrimSpace(u[1])
+			}
+		}
+	}
+	for _, re := range []*regexp.Regexp{jsLocationRegex, jsLocationFuncRegex} {
+		if m := re.FindSubmatch(body); m != nil {
+			return RedirectTypeJavaScript, strings.TrimSpace(string(m[1]))
+		}
+	}
+	return "", ""
+}
+
+// registrableDomain eTLD+1, ip and single label hosts are returned as is
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(host))
+	if err != nil {
+		return strings.ToLower(host)
+	}
+	return domain
+}
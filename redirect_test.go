@@ -0,0 +1,181 @@
+package shttp
+
+import (
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirect_Chain(t *testing.T) {
+	ts := testhttp.CreateRedirectServer(t)
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.MaxRedirect = 3
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+
+	hr, _ := http.NewRequest("GET", ts.URL+"/redirect-1", nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, "/redirect-3", resp.GetUrl().Path)
+
+	chain := resp.GetRedirectChain()
+	require.Len(t, chain, 2)
+	for i, hop := range chain {
+		require.Equal(t, RedirectTypeHTTP, hop.Type)
+		require.Equal(t, http.StatusTemporaryRedirect, hop.StatusCode)
+		require.Equal(t, "/redirect-"+string(rune('1'+i)), hop.Request.URL.Path)
+		require.Equal(t, "/redirect-"+string(rune('2'+i)), hop.Location)
+		require.False(t, hop.SendAt.IsZero())
+		require.True(t, hop.Duration() >= 0)
+	}
+}
+
+func TestRedirect_Policies(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	u, _ := url.Parse(target.URL)
+	// 127.0.0.1 -> localhost changes the host name
+	otherHost := "http://localhost:" + u.Port() + "/"
+	redirectTo := func(location string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, location, http.StatusFound)
+		}
+	}
+	plain := httptest.NewServer(redirectTo(otherHost))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(redirectTo(target.URL))
+	defer secure.Close()
+
+	testcases := []struct {
+		policy   string
+		url      string
+		followed bool
+	}{
+		{RedirectPolicySameHost, plain.URL, false},
+		{RedirectPolicySameDomain, plain.URL, false},
+		{RedirectPolicyNoDowngrade, plain.URL, true},
+		{RedirectPolicyNoDowngrade, secure.URL, false},
+		{RedirectPolicySameHost, secure.URL, true},
+	}
+	for _, tc := range testcases {
+		options := DefaultClientOptions()
+		options.RedirectPolicies = []string{tc.policy}
+		client, err := NewRedirectClient(options, nil)
+		require.Nil(t, err)
+		hr, _ := http.NewRequest("GET", tc.url, nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		if tc.followed {
+			require.Equal(t, "target", string(resp.GetBody()), tc.policy)
+			require.Len(t, resp.GetRedirectChain(), 1)
+		} else {
+			require.Equal(t, http.StatusFound, resp.GetStatus(), tc.policy)
+			require.Empty(t, resp.GetRedirectChain())
+		}
+	}
+}
+
+func TestRedirect_Callback(t *testing.T) {
+	ts := testhttp.CreateRedirectServer(t)
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.RedirectCallback = func(req *http.Request, via []*http.Request) error {
+		if strings.HasSuffix(req.URL.Path, "-3") {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+	hr, _ := http.NewRequest("GET", ts.URL+"/redirect-1", nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Equal(t, "/redirect-2", resp.GetUrl().Path)
+	require.Len(t, resp.GetRedirectChain(), 1)
+}
+
+func TestRedirect_Browser(t *testing.T) {
+	ts := testhttp.CreateBrowserRedirectServer(t)
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.BrowserRedirect = true
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	hr, _ := http.NewRequest("GET", ts.URL+"/meta-refresh", nil)
+	req := &Request{RawRequest: hr}
+	resp, err := client.Do(ctx, req)
+	require.Nil(t, err)
+	require.Equal(t, "browser redirect final page", string(resp.GetBody()))
+	require.Equal(t, req, resp.Request)
+	chain := resp.GetRedirectChain()
+	require.Len(t, chain, 3)
+	require.Equal(t, RedirectTypeMetaRefresh, chain[0].Type)
+	require.Equal(t, "/js-location", chain[0].Location)
+	require.Equal(t, RedirectTypeJavaScript, chain[1].Type)
+	require.Equal(t, RedirectTypeJavaScript, chain[2].Type)
+	require.Equal(t, "/final", chain[2].Location)
+
+	hr, _ = http.NewRequest("GET", ts.URL+"/self-refresh", nil)
+	resp, err = client.Do(ctx, &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Empty(t, resp.GetRedirectChain())
+
+	// loops stop at MaxRedirect
+	hr, _ = http.NewRequest("GET", ts.URL+"/loop", nil)
+	resp, err = client.Do(ctx, &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Len(t, resp.GetRedirectChain(), options.MaxRedirect-1)
+
+	// not enabled on clients that don't follow redirects
+	noRedirectClient, err := NewClient(options, nil)
+	require.Nil(t, err)
+	hr, _ = http.NewRequest("GET", ts.URL+"/meta-refresh", nil)
+	resp, err = noRedirectClient.Do(ctx, &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Empty(t, resp.GetRedirectChain())
+}
+
+func TestRedirect_BrowserCookie(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("cookie=" + r.Header.Get("Cookie")))
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/same":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="0;url=/echo">`))
+		case "/cross":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="0;url=` + other.URL + `/echo">`))
+		case "/echo":
+			_, _ = w.Write([]byte("cookie=" + r.Header.Get("Cookie")))
+		}
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.BrowserRedirect = true
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+	for path, expected := range map[string]string{"/same": "cookie=session=1", "/cross": "cookie="} {
+		hr, _ := http.NewRequest("GET", ts.URL+path, nil)
+		hr.Header.Set("Cookie", "session=1")
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		require.Nil(t, err)
+		require.Len(t, resp.GetRedirectChain(), 1, path)
+		require.Equal(t, expected, string(resp.GetBody()), path)
+	}
+}
@@ -3,13 +3,16 @@ package shttp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,8 +29,26 @@ type Request struct {
 	ctx         context.Context
 	raw         []byte
 	trace       bool
+	protocol    string
 	sendAt      time.Time
 	clientTrace *clientTrace
+	redirects   []*RedirectHop
+	hopStart    time.Time
+	// redirectOffset hops followed before this request by the browser-like redirect
+	redirectOffset int
+	// hopHost host name of the hop in flight, read by the tls handshake
+	hopHost atomic.Value
+	// connState tls state of custom ClientHello connections, net/http leaves RawResponse.TLS nil for them
+	connState *tls.ConnectionState
+	// localAddr, remoteAddr of the connection the request was sent on, the proxy when there is one
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// chunked body sent with its exact framing instead of Body, see SetChunkedBody
+	chunked *ChunkedBody
+	// lenient raw bytes and anomalies of the response when LenientResponse is on
+	lenient *lenientResponse
+	// direct the response came on a connection of our own, see Client.roundTripDirect
+	direct bool
 }
 
 //‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
@@ -39,6 +60,8 @@ func (r *Request) Clone() *Request {
 		RawRequest: r.RawRequest.Clone(r.RawRequest.Context()),
 		Error:      r.Error,
 		Body:       r.Body,
+		protocol:   r.protocol,
+		chunked:    r.chunked,
 	}
 }
 
@@ -50,6 +73,11 @@ func (r *Request) GetContext() context.Context {
 	return r.ctx
 }
 
+// GetChunkedBody nil unless the body was set by SetChunkedBody
+func (r *Request) GetChunkedBody() *ChunkedBody {
+	return r.chunked
+}
+
 // GetAttempt get
 func (r *Request) GetAttempt() int {
 	return r.attempt
@@ -125,6 +153,14 @@ func (r *Request) GetRaw() ([]byte, error) {
 	if r.raw != nil {
 		return r.raw, nil
 	}
+	if r.chunked != nil {
+		raw, err := serializeChunked(r.GetContext(), r.RawRequest, r.chunked)
+		if err != nil {
+			return nil, err
+		}
+		r.raw = raw
+		return r.raw, nil
+	}
 	// Dump请求头
 	reqHeaderRaw, err := httputil.DumpRequest(r.RawRequest, false)
 	if err != nil {
@@ -193,6 +229,12 @@ func (r *Request) EnableTrace() *Request {
 	return r
 }
 
+// SetProtocol overrides ClientOptions.Protocol for this request, see ProtocolHTTP1 and friends
+func (r *Request) SetProtocol(protocol string) *Request {
+	r.protocol = protocol
+	return r
+}
+
 func (r *Request) setSendAt() *Request {
 	r.sendAt = time.Now()
 	return r
@@ -248,3 +290,14 @@ func (r *Request) SetBody(body []byte) *Request {
 	r.RawRequest.ContentLength = int64(len(body))
 	return r
 }
+
+// SetChunkedBody sends the body with Transfer-Encoding: chunked framed exactly as
+// described, Body holds the wire form and GetRaw the bytes sent
+func (r *Request) SetChunkedBody(body *ChunkedBody) *Request {
+	r.SetBody(body.Bytes())
+	r.chunked = body
+	r.raw = nil
+	r.RawRequest.ContentLength = -1
+	r.RawRequest.TransferEncoding = []string{"chunked"}
+	return r
+}
@@ -2,9 +2,12 @@ package shttp
 
 import (
 	"fmt"
+	"github.com/iami317/shttp/xtls"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -17,8 +20,15 @@ type Response struct {
 	RawResponse *http.Response
 	Body        []byte
 
-	raw        []byte
-	receivedAt time.Time
+	raw         []byte
+	receivedAt  time.Time
+	cacheStatus string
+	redirects   []*RedirectHop
+	tlsVerify   *xtls.VerifyResult
+	tlsInfo     *TLSInfo
+	// wire bytes as received and anomalies found by the lenient parser
+	wire      []byte
+	anomalies []string
 }
 
 //‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
@@ -58,7 +68,62 @@ func (r *Response) GetBody() []byte {
 	return r.Body
 }
 
+// GetRedirectChain followed redirect hops in order, the final response is not included
+func (r *Response) GetRedirectChain() []*RedirectHop {
+	return r.redirects
+}
+
+// GetCacheStatus CacheStatusMiss / CacheStatusHit / CacheStatusRevalidated, empty when the cache is disabled
+func (r *Response) GetCacheStatus() string {
+	return r.cacheStatus
+}
+
+// GetTLSVerifyResult certificate checks of the connection, nil for plain http, when verification is disabled
+// or when it is enforced, a failed check fails the request then
+func (r *Response) GetTLSVerifyResult() *xtls.VerifyResult {
+	return r.tlsVerify
+}
+
+// GetProtocol protocol the response was received with: http/1.0, http/1.1, h2, h2c or h3
+func (r *Response) GetProtocol() string {
+	if r.RawResponse.ProtoMajor == 3 {
+		return ProtocolH3
+	}
+	if r.RawResponse.ProtoMajor == 2 {
+		if r.RawResponse.TLS != nil || r.tlsInfo != nil {
+			return ProtocolH2
+		}
+		return ProtocolH2C
+	}
+	return strings.ToLower(r.RawResponse.Proto)
+}
+
+// GetLocalAddr local address of the connection the response came on, nil when no
+// connection was used (cached responses, h3)
+func (r *Response) GetLocalAddr() net.Addr {
+	return r.Request.localAddr
+}
+
+// GetRemoteAddr remote address of the connection, the proxy's when there is one
+func (r *Response) GetRemoteAddr() net.Addr {
+	return r.Request.remoteAddr
+}
+
+// GetTLSInfo negotiated tls parameters and peer chain, nil for plain http
+func (r *Response) GetTLSInfo() *TLSInfo {
+	return r.tlsInfo
+}
+
+// GetAnomalies protocol anomalies of the response, only found with LenientResponse on
+func (r *Response) GetAnomalies() []string {
+	return r.anomalies
+}
+
+// GetRaw response bytes, as received when LenientResponse is on
 func (r *Response) GetRaw() ([]byte, error) {
+	if r.wire != nil {
+		return r.wire, nil
+	}
 	// dump 响应头
 	respHeaderRaw, err := httputil.DumpResponse(r.RawResponse, false)
 	if err != nil {
@@ -89,3 +154,17 @@ func (r *Response) setReceivedAt() {
 		r.Request.clientTrace.endTime = r.receivedAt
 	}
 }
+
+// setTLSInfo prefers the state captured by the trace hook, reused connections fall back to RawResponse.TLS,
+// custom ClientHello connections to the state of the connection
+func (r *Response) setTLSInfo() {
+	if ct := r.Request.clientTrace; ct != nil && ct.tlsState != nil {
+		r.tlsInfo = newTLSInfo(ct.tlsState)
+		return
+	}
+	if r.RawResponse != nil && r.RawResponse.TLS != nil {
+		r.tlsInfo = newTLSInfo(r.RawResponse.TLS)
+		return
+	}
+	r.tlsInfo = newTLSInfo(r.Request.connState)
+}
@@ -2,9 +2,15 @@ package shttp
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/iami317/shttp/xtls"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -39,3 +45,88 @@ func TestNewResponse(t *testing.T) {
 	}
 	require.Equal(t, flag, true)
 }
+
+func TestResponse_TLSVerifyResult(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.TlsOptions.TLSVerifyReportOnly = true
+	options.TlsOptions.Pins = map[string][]string{"127.0.0.1": {xtls.SPKIPin(ts.Certificate())}}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	hr, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err, "report only must not fail on the untrusted test certificate")
+	result := resp.GetTLSVerifyResult()
+	require.NotNil(t, result)
+	require.False(t, result.ChainVerified)
+	require.True(t, result.PinMatched)
+	require.NotNil(t, result.Err)
+}
+
+func TestResponse_TLSPinEnforced(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	for pin, ok := range map[string]bool{
+		xtls.SPKIPin(ts.Certificate()):                                           true,
+		"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)): false,
+	} {
+		var calls int
+		options := DefaultClientOptions()
+		// ip targets send no sni, the pin of the dialed host must still apply
+		options.TlsOptions.Pins = map[string][]string{"127.0.0.1": {pin}}
+		options.TlsOptions.VerifyCallback = func(host string, chain []*x509.Certificate, result *xtls.VerifyResult) error {
+			calls++
+			require.Equal(t, "127.0.0.1", host)
+			return nil
+		}
+		client, err := NewClient(options, nil)
+		require.Nil(t, err)
+
+		hr, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+		if !ok {
+			require.True(t, errors.Is(err, xtls.ErrPinMismatch), err)
+			continue
+		}
+		require.Nil(t, err)
+		require.Nil(t, resp.GetTLSVerifyResult(), "enforced checks ran in the handshake")
+		require.Equal(t, 1, calls)
+	}
+}
+
+func TestResponse_TLSInfo(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	for _, trace := range []bool{true, false} {
+		hr, _ := http.NewRequest("GET", ts.URL, nil)
+		req := &Request{RawRequest: hr}
+		if trace {
+			req.EnableTrace()
+		}
+		resp, err := client.Do(context.Background(), req)
+		require.Nil(t, err)
+		info := resp.GetTLSInfo()
+		require.NotNil(t, info)
+		require.Equal(t, "TLS 1.3", info.Version)
+		require.NotEmpty(t, info.CipherSuite)
+		require.Empty(t, info.ServerName, "no sni for ip targets")
+		require.Len(t, info.Certificates, 1)
+		require.Equal(t, []string{"127.0.0.1", "::1"}, info.Certificates[0].IPAddresses)
+		require.False(t, info.Certificates[0].Expired())
+		require.Equal(t, ts.Certificate().NotAfter, info.Certificates[0].NotAfter)
+	}
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	hr, _ := http.NewRequest("GET", plain.URL, nil)
+	resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+	require.Nil(t, resp.GetTLSInfo())
+}
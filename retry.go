@@ -3,6 +3,7 @@ package shttp
 import (
 	"context"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -34,6 +35,12 @@ func defaultRetryPolicy(ctx context.Context, resp *http.Response, err error) (bo
 
 func baseRetryPolicy(resp *http.Response, err error) (bool, error) {
 	if err != nil {
+		// Don't retry if the request left the scope.
+		var scopeErr *OutOfScopeError
+		if errors.As(err, &scopeErr) {
+			return false, err
+		}
+
 		if v, ok := err.(*url.Error); ok {
 			// Don't retry if the error was due to too many redirects.
 			if redirectsErrorRegex.MatchString(v.Error()) {
@@ -0,0 +1,292 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSchedulerClosed returned when submitting to (or waiting on) a closed Scheduler
+var ErrSchedulerClosed = errors.New("scheduler closed")
+
+const (
+	// PriorityLow discovery / brute force requests
+	PriorityLow = 0
+	// PriorityNormal default priority
+	PriorityNormal = 1
+	// PriorityHigh verification requests, dispatched before any lower priority
+	PriorityHigh = 2
+)
+
+// SchedulerOptions scheduler options
+type SchedulerOptions struct {
+	// Workers number of requests executed concurrently
+	Workers int `json:"workers" yaml:"workers" #:"调度器并发执行的请求数"`
+	// Weights per queue key weight, keys not listed use weight 1
+	Weights map[string]int `json:"weights" yaml:"weights" #:"队列权重, key 为 host 或任务名, 未配置的默认为 1"`
+}
+
+// DefaultSchedulerOptions default scheduler options
+func DefaultSchedulerOptions() *SchedulerOptions {
+	return &SchedulerOptions{
+		Workers: 50,
+		Weights: make(map[string]int),
+	}
+}
+
+// ScheduleResult result of a scheduled request
+type ScheduleResult struct {
+	Request  *Request
+	Response *Response
+	Err      error
+}
+
+type scheduleJob struct {
+	ctx    context.Context
+	req    *Request
+	result chan *ScheduleResult
+	queue  *scheduleQueue
+}
+
+// scheduleQueue pending jobs of one host/task, grouped by priority
+type scheduleQueue struct {
+	key           string
+	weight        int
+	currentWeight int
+	pending       map[int][]*scheduleJob
+	depth         int
+	running       int
+}
+
+// Scheduler sits in front of Client.Do and dispatches requests fairly across
+// hosts/tasks: higher priorities first, then smooth weighted round-robin
+// between the queues that have work at that priority.
+type Scheduler struct {
+	client  *Client
+	options *SchedulerOptions
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*scheduleQueue
+	order  []string // queue keys by creation, keeps the round-robin deterministic
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler for client and starts its workers
+func NewScheduler(client *Client, options *SchedulerOptions) (*Scheduler, error) {
+	if client == nil {
+		return nil, errors.New("xhttp client not instantiated")
+	}
+	if options == nil {
+		options = DefaultSchedulerOptions()
+	}
+	if options.Workers <= 0 {
+		return nil, errors.New("scheduler workers must be greater than 0")
+	}
+	s := &Scheduler{
+		client:  client,
+		options: options,
+		queues:  make(map[string]*scheduleQueue),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for i := 0; i < options.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s, nil
+}
+
+// Submit queues req under key (host of req when key is empty) with priority,
+// the result is delivered on the returned channel.
+func (s *Scheduler) Submit(ctx context.Context, key string, priority int, req *Request) <-chan *ScheduleResult {
+	result := make(chan *ScheduleResult, 1)
+	if key == "" {
+		key = req.GetHost()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		result <- &ScheduleResult{Request: req, Err: ErrSchedulerClosed}
+		return result
+	}
+	q, ok := s.queues[key]
+	if !ok {
+		q = &scheduleQueue{
+			key:     key,
+			weight:  s.weightOf(key),
+			pending: make(map[int][]*scheduleJob),
+		}
+		s.queues[key] = q
+		s.order = append(s.order, key)
+	}
+	q.pending[priority] = append(q.pending[priority], &scheduleJob{ctx: ctx, req: req, result: result, queue: q})
+	q.depth++
+	s.cond.Signal()
+	return result
+}
+
+// Do submits req and waits for its response
+func (s *Scheduler) Do(ctx context.Context, key string, priority int, req *Request) (*Response, error) {
+	select {
+	case r := <-s.Submit(ctx, key, priority, req):
+		return r.Response, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetWeight changes the weight of queue key
+func (s *Scheduler) SetWeight(key string, weight int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.Weights == nil {
+		s.options.Weights = make(map[string]int)
+	}
+	s.options.Weights[key] = weight
+	if q, ok := s.queues[key]; ok {
+		q.weight = s.weightOf(key)
+	}
+}
+
+// QueueDepth returns the number of pending requests per queue key
+func (s *Scheduler) QueueDepth() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	depth := make(map[string]int, len(s.queues))
+	for key, q := range s.queues {
+		depth[key] = q.depth
+	}
+	return depth
+}
+
+// Close stops accepting requests, fails pending ones with ErrSchedulerClosed
+// and waits for running requests to finish.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for _, q := range s.queues {
+		for _, jobs := range q.pending {
+			for _, job := range jobs {
+				job.result <- &ScheduleResult{Request: job.req, Err: ErrSchedulerClosed}
+			}
+		}
+	}
+	s.queues = make(map[string]*scheduleQueue)
+	s.order = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) weightOf(key string) int {
+	if w, ok := s.options.Weights[key]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+	for {
+		job := s.next()
+		if job == nil {
+			return
+		}
+		if err := job.ctx.Err(); err != nil {
+			s.done(job)
+			job.result <- &ScheduleResult{Request: job.req, Err: err}
+			continue
+		}
+		resp, err := s.client.Do(job.ctx, job.req)
+		s.done(job)
+		job.result <- &ScheduleResult{Request: job.req, Response: resp, Err: err}
+	}
+}
+
+// done forgets the queue of job once it has nothing pending or running, so
+// hosts seen once don't pile up
+func (s *Scheduler) done(job *scheduleJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := job.queue
+	q.running--
+	if q.depth > 0 || q.running > 0 || s.queues[q.key] != q {
+		return
+	}
+	delete(s.queues, q.key)
+	for i, key := range s.order {
+		if key == q.key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// next blocks until a job is available, returns nil once the scheduler is closed
+func (s *Scheduler) next() *scheduleJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.closed {
+			return nil
+		}
+		if job := s.pick(); job != nil {
+			return job
+		}
+		s.cond.Wait()
+	}
+}
+
+// pick must be called with s.mu held
+func (s *Scheduler) pick() *scheduleJob {
+	var (
+		found    bool
+		priority int
+	)
+	for _, key := range s.order {
+		for p, jobs := range s.queues[key].pending {
+			if len(jobs) > 0 && (!found || p > priority) {
+				found, priority = true, p
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	// smooth weighted round-robin between queues having work at priority
+	var (
+		best  *scheduleQueue
+		total int
+	)
+	for _, key := range s.order {
+		q := s.queues[key]
+		if len(q.pending[priority]) == 0 {
+			continue
+		}
+		q.currentWeight += q.weight
+		total += q.weight
+		if best == nil || q.currentWeight > best.currentWeight {
+			best = q
+		}
+	}
+	best.currentWeight -= total
+
+	jobs := best.pending[priority]
+	job := jobs[0]
+	jobs[0] = nil
+	if len(jobs) == 1 {
+		delete(best.pending, priority)
+	} else {
+		best.pending[priority] = jobs[1:]
+	}
+	best.depth--
+	best.running++
+	return job
+}
@@ -0,0 +1,81 @@
+package shttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestScheduler_Fair(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		order = append(order, r.URL.Query().Get("q"))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	scheduler, err := NewScheduler(client, &SchedulerOptions{Workers: 1})
+	require.Nil(t, err)
+	defer scheduler.Close()
+
+	ctx := context.Background()
+	newReq := func(path string) *Request {
+		hr, _ := http.NewRequest("GET", ts.URL+path, nil)
+		return &Request{RawRequest: hr}
+	}
+
+	// occupy the only worker until everything is queued
+	first := scheduler.Submit(ctx, "a", PriorityLow, newReq("/block?q=block"))
+	<-started
+	var results []<-chan *ScheduleResult
+	for i := 0; i < 4; i++ {
+		results = append(results, scheduler.Submit(ctx, "a", PriorityLow, newReq("/?q=a")))
+	}
+	for i := 0; i < 2; i++ {
+		results = append(results, scheduler.Submit(ctx, "b", PriorityLow, newReq("/?q=b")))
+	}
+	results = append(results, scheduler.Submit(ctx, "c", PriorityHigh, newReq("/?q=verify")))
+	require.Equal(t, map[string]int{"a": 4, "b": 2, "c": 1}, scheduler.QueueDepth())
+
+	close(release)
+	require.Nil(t, (<-first).Err)
+	for _, result := range results {
+		r := <-result
+		require.Nil(t, r.Err)
+		require.Equal(t, 200, r.Response.GetStatus())
+	}
+	require.Equal(t, []string{"block", "verify", "a", "b", "a", "b", "a", "a"}, order)
+
+	// drained queues are dropped
+	require.Empty(t, scheduler.QueueDepth())
+	scheduler.mu.Lock()
+	require.Empty(t, scheduler.order)
+	scheduler.mu.Unlock()
+}
+
+func TestScheduler_Close(t *testing.T) {
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	scheduler, err := NewScheduler(client, nil)
+	require.Nil(t, err)
+	scheduler.Close()
+
+	hr, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	_, err = scheduler.Do(context.Background(), "", PriorityNormal, &Request{RawRequest: hr})
+	require.Equal(t, ErrSchedulerClosed, err)
+}
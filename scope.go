@@ -0,0 +1,287 @@
+package shttp
+
+import (
+	"fmt"
+	"github.com/thoas/go-funk"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ScopeOptions outbound request scope, deny rules win over allow rules
+type ScopeOptions struct {
+	AllowDomains []string `json:"allow_domains" yaml:"allow_domains" #:"允许访问的域名, 支持通配符, 如: *.example.com"`
+	DenyDomains  []string `json:"deny_domains" yaml:"deny_domains" #:"禁止访问的域名, 支持通配符"`
+	AllowCIDRs   []string `json:"allow_cidrs" yaml:"allow_cidrs" #:"允许访问的 ip 段, 如: 10.0.0.0/8"`
+	DenyCIDRs    []string `json:"deny_cidrs" yaml:"deny_cidrs" #:"禁止访问的 ip 段, dns 解析后同样会检查"`
+	AllowPorts   []string `json:"allow_ports" yaml:"allow_ports" #:"允许访问的端口, 如: 80, 8000-9000"`
+	DenyPorts    []string `json:"deny_ports" yaml:"deny_ports" #:"禁止访问的端口"`
+	Schemes      []string `json:"schemes" yaml:"schemes" #:"允许的协议, 为空不限制"`
+	DenyInternal bool     `json:"deny_internal" yaml:"deny_internal" #:"禁止连接内网/回环等地址(dns 解析后检查, 防止 dns rebinding), allow_cidrs 中的地址除外"`
+}
+
+// Clone scope options
+func (o *ScopeOptions) Clone() *ScopeOptions {
+	newOptions := *o
+	newOptions.AllowDomains = append([]string(nil), o.AllowDomains...)
+	newOptions.DenyDomains = append([]string(nil), o.DenyDomains...)
+	newOptions.AllowCIDRs = append([]string(nil), o.AllowCIDRs...)
+	newOptions.DenyCIDRs = append([]string(nil), o.DenyCIDRs...)
+	newOptions.AllowPorts = append([]string(nil), o.AllowPorts...)
+	newOptions.DenyPorts = append([]string(nil), o.DenyPorts...)
+	newOptions.Schemes = append([]string(nil), o.Schemes...)
+	return &newOptions
+}
+
+// OutOfScopeError returned when a request, redirect or connection leaves the scope
+type OutOfScopeError struct {
+	Target string
+	Reason string
+}
+
+func (e *OutOfScopeError) Error() string {
+	return fmt.Sprintf("%s out of scope: %s", e.Target, e.Reason)
+}
+
+type portRange struct {
+	from, to int
+}
+
+// scope compiled ScopeOptions
+type scope struct {
+	allowDomains []string
+	denyDomains  []string
+	allowNets    []*net.IPNet
+	denyNets     []*net.IPNet
+	allowPorts   []portRange
+	denyPorts    []portRange
+	schemes      []string
+	denyInternal bool
+}
+
+// newScope returns nil when options is nil, i.e. everything is in scope
+func newScope(options *ScopeOptions) (*scope, error) {
+	if options == nil {
+		return nil, nil
+	}
+	var err error
+	s := &scope{denyInternal: options.DenyInternal}
+	for _, d := range options.AllowDomains {
+		s.allowDomains = append(s.allowDomains, strings.TrimSuffix(strings.ToLower(d), "."))
+	}
+	for _, d := range options.DenyDomains {
+		s.denyDomains = append(s.denyDomains, strings.TrimSuffix(strings.ToLower(d), "."))
+	}
+	for _, scheme := range options.Schemes {
+		s.schemes = append(s.schemes, strings.ToLower(scheme))
+	}
+	if s.allowNets, err = parseCIDRs(options.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if s.denyNets, err = parseCIDRs(options.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	if s.allowPorts, err = parsePortRanges(options.AllowPorts); err != nil {
+		return nil, err
+	}
+	if s.denyPorts, err = parsePortRanges(options.DenyPorts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkURL request time and redirect check
+func (s *scope) checkURL(u *url.URL) error {
+	if s == nil {
+		return nil
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if len(s.schemes) > 0 && !funk.ContainsString(s.schemes, scheme) {
+		return &OutOfScopeError{Target: u.String(), Reason: "scheme " + scheme + " not allowed"}
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	if port != "" {
+		if err := s.checkPort(port); err != nil {
+			err.Target = u.String()
+			return err
+		}
+	}
+
+	// evil.com. is the same host as evil.com
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		if err := s.checkIP(ip, false); err != nil {
+			err.Target = u.String()
+			return err
+		}
+		if len(s.allowNets) == 0 && len(s.allowDomains) > 0 && !matchDomain(s.allowDomains, host) {
+			return &OutOfScopeError{Target: u.String(), Reason: "ip " + host + " not in allowed domains"}
+		}
+		return nil
+	}
+
+	if matchDomain(s.denyDomains, host) {
+		return &OutOfScopeError{Target: u.String(), Reason: "domain " + host + " denied"}
+	}
+	if len(s.allowDomains) > 0 && !matchDomain(s.allowDomains, host) {
+		return &OutOfScopeError{Target: u.String(), Reason: "domain " + host + " not allowed"}
+	}
+	if len(s.allowDomains) == 0 && len(s.allowNets) > 0 {
+		return &OutOfScopeError{Target: u.String(), Reason: "domain " + host + " not allowed"}
+	}
+	return nil
+}
+
+// checkIP dial parameter true when called after dns resolution
+func (s *scope) checkIP(ip net.IP, dial bool) *OutOfScopeError {
+	for _, n := range s.denyNets {
+		if n.Contains(ip) {
+			return &OutOfScopeError{Target: ip.String(), Reason: "ip in denied cidr " + n.String()}
+		}
+	}
+	allowed := false
+	for _, n := range s.allowNets {
+		if n.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+	if !dial && len(s.allowNets) > 0 && !allowed {
+		return &OutOfScopeError{Target: ip.String(), Reason: "ip not in allowed cidrs"}
+	}
+	if s.denyInternal && !allowed && isInternalIP(ip) {
+		return &OutOfScopeError{Target: ip.String(), Reason: "internal address denied"}
+	}
+	return nil
+}
+
+func (s *scope) checkPort(port string) *OutOfScopeError {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return &OutOfScopeError{Reason: "invalid port " + port}
+	}
+	if inPortRanges(s.denyPorts, p) {
+		return &OutOfScopeError{Reason: "port " + port + " denied"}
+	}
+	if len(s.allowPorts) > 0 && !inPortRanges(s.allowPorts, p) {
+		return &OutOfScopeError{Reason: "port " + port + " not allowed"}
+	}
+	return nil
+}
+
+// control for net.Dialer, nil when everything is in scope
+func (s *scope) control() func(network, address string, c syscall.RawConn) error {
+	if s == nil {
+		return nil
+	}
+	return s.dialControl
+}
+
+// dialControl net.Dialer.Control, sees the resolved address right before connect
+func (s *scope) dialControl(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if e := s.checkPort(port); e != nil {
+		e.Target = address
+		return e
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &OutOfScopeError{Target: address, Reason: "unresolved address"}
+	}
+	if e := s.checkIP(ip, true); e != nil {
+		e.Target = address
+		return e
+	}
+	return nil
+}
+
+func isInternalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	// 100.64.0.0/10 carrier-grade nat
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return true
+	}
+	return false
+}
+
+func matchDomain(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope cidr %s: %v", cidr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func parsePortRanges(ports []string) ([]portRange, error) {
+	var ranges []portRange
+	for _, p := range ports {
+		for _, item := range strings.Split(p, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			from, to := item, item
+			if i := strings.Index(item, "-"); i >= 0 {
+				from, to = item[:i], item[i+1:]
+			}
+			f, err1 := strconv.Atoi(strings.TrimSpace(from))
+			t, err2 := strconv.Atoi(strings.TrimSpace(to))
+			if err1 != nil || err2 != nil || f > t {
+				return nil, fmt.Errorf("invalid scope port %s", item)
+			}
+			ranges = append(ranges, portRange{from: f, to: t})
+		}
+	}
+	return ranges, nil
+}
+
+func inPortRanges(ranges []portRange, port int) bool {
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
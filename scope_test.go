@@ -0,0 +1,93 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestScope_Request(t *testing.T) {
+	s, err := newScope(&ScopeOptions{
+		AllowDomains: []string{"*.example.com", "example.com"},
+		DenyDomains:  []string{"admin.example.com"},
+		AllowPorts:   []string{"80,443", "8000-8100"},
+		Schemes:      []string{"http", "https"},
+	})
+	require.Nil(t, err)
+
+	testcases := []struct {
+		url     string
+		inScope bool
+	}{
+		{"http://example.com/", true},
+		{"https://www.example.com/", true},
+		{"http://a.b.example.com:8080/", true},
+		{"http://admin.example.com/", false},
+		{"http://admin.example.com./", false},
+		{"http://www.example.com./", true},
+		{"http://example.org/", false},
+		{"http://example.com:22/", false},
+		{"ftp://example.com/", false},
+		{"http://127.0.0.1/", false},
+	}
+	for _, tc := range testcases {
+		u, _ := url.Parse(tc.url)
+		err := s.checkURL(u)
+		require.Equal(t, tc.inScope, err == nil, tc.url)
+	}
+
+	_, err = newScope(&ScopeOptions{AllowCIDRs: []string{"10.0.0.0/33"}})
+	require.NotNil(t, err)
+}
+
+func TestScope_Redirect(t *testing.T) {
+	ts := testhttp.CreateRedirectServer(t)
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.Scope = &ScopeOptions{AllowCIDRs: []string{"127.0.0.1"}}
+	client, err := NewRedirectClient(options, nil)
+	require.Nil(t, err)
+
+	// redirect-host-check-5 jumps to httpbin.org
+	hr, _ := http.NewRequest("GET", ts.URL+"/redirect-host-check-1", nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	var scopeErr *OutOfScopeError
+	require.True(t, errors.As(err, &scopeErr), "%v", err)
+	require.True(t, strings.HasPrefix(scopeErr.Target, "http://httpbin.org"))
+
+	hr, _ = http.NewRequest("GET", "http://example.com/", nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.True(t, errors.As(err, &scopeErr))
+}
+
+func TestScope_DenyInternal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	options := DefaultClientOptions()
+	options.Scope = &ScopeOptions{DenyInternal: true}
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+
+	// the name passes the request check, the resolved loopback address is refused at dial time
+	hr, _ := http.NewRequest("GET", "http://localhost:"+u.Port()+"/", nil)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	var scopeErr *OutOfScopeError
+	require.True(t, errors.As(err, &scopeErr), "%v", err)
+	require.Equal(t, "internal address denied", scopeErr.Reason)
+
+	options.Scope.AllowCIDRs = []string{"127.0.0.0/8", "::1"}
+	options.Scope.AllowDomains = []string{"localhost"}
+	client, err = NewClient(options, nil)
+	require.Nil(t, err)
+	_, err = client.Do(context.Background(), &Request{RawRequest: hr})
+	require.Nil(t, err)
+}
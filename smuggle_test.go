@@ -0,0 +1,107 @@
+package shttp
+
+import (
+	"context"
+	testhttp "github.com/iami317/shttp/testutils/http"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient_DetectSmuggling(t *testing.T) {
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	h1 := []string{SmuggleCLTE, SmuggleTECL, SmuggleTETE}
+
+	for _, c := range []struct {
+		front, back string
+		technique   string
+		desync      string
+	}{
+		{testhttp.FramingCL, testhttp.FramingTE, SmuggleCLTE, ""},
+		{testhttp.FramingTE, testhttp.FramingCL, SmuggleTECL, ""},
+		{testhttp.FramingTEStrict, testhttp.FramingTE, SmuggleTETE, SmuggleCLTE},
+	} {
+		target := testhttp.CreateSmuggleServer(t, c.front, c.back)
+		findings, err := client.DetectSmuggling(context.Background(), target, &SmuggleOptions{Techniques: h1, Timeout: 1, Differential: true})
+		require.Nil(t, err)
+		require.Len(t, findings, 2, c.technique)
+
+		timing, differential := findings[0], findings[1]
+		require.Equal(t, c.technique, timing.Technique)
+		require.Equal(t, SmuggleTiming, timing.Detection)
+		require.Equal(t, c.desync, timing.Desync)
+		require.Equal(t, 200, timing.Probes[0].Status)
+		require.True(t, timing.Probes[1].TimedOut)
+		require.True(t, strings.HasPrefix(string(timing.Probes[1].Request), "POST / HTTP/1.1\r\n"))
+
+		require.Equal(t, c.technique, differential.Technique)
+		require.Equal(t, SmuggleDifferential, differential.Detection)
+		require.Len(t, differential.Probes, 3)
+		require.Contains(t, string(differential.Probes[1].Request), "GET /smuggle-")
+		require.Equal(t, 404, differential.Probes[2].Status)
+		if c.technique == SmuggleTETE {
+			require.Equal(t, DefaultTEObfuscations[0], timing.Obfuscation)
+			require.Contains(t, string(timing.Probes[1].Request), timing.Obfuscation+"\r\n")
+		}
+	}
+
+	// both hops agree
+	target := testhttp.CreateSmuggleServer(t, testhttp.FramingTE, testhttp.FramingTE)
+	findings, err := client.DetectSmuggling(context.Background(), target, &SmuggleOptions{Techniques: h1, Timeout: 1, Differential: true})
+	require.Nil(t, err)
+	require.Empty(t, findings)
+
+	// a single hop
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer ts.Close()
+	findings, err = client.DetectSmuggling(context.Background(), ts.URL, &SmuggleOptions{Techniques: h1, Timeout: 1, Differential: true})
+	require.Nil(t, err)
+	require.Empty(t, findings)
+}
+
+func TestClient_DetectSmugglingH2(t *testing.T) {
+	client, err := NewClient(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	options := &SmuggleOptions{Techniques: []string{SmuggleH2CL, SmuggleH2TE}, Timeout: 1, Differential: true}
+
+	findings, err := client.DetectSmuggling(context.Background(), testhttp.CreateH2SmuggleServer(t, false), options)
+	require.Nil(t, err)
+	require.Len(t, findings, 4)
+	for i, technique := range []string{SmuggleH2CL, SmuggleH2CL, SmuggleH2TE, SmuggleH2TE} {
+		require.Equal(t, technique, findings[i].Technique)
+	}
+	require.Equal(t, SmuggleTiming, findings[0].Detection)
+	require.Contains(t, string(findings[0].Probes[1].Request), "content-length: 10\r\n")
+	require.Equal(t, SmuggleDifferential, findings[3].Detection)
+	require.Contains(t, string(findings[3].Probes[1].Request), "transfer-encoding: chunked\r\n")
+	require.Equal(t, 404, findings[3].Probes[2].Status)
+
+	findings, err = client.DetectSmuggling(context.Background(), testhttp.CreateH2SmuggleServer(t, true), options)
+	require.Nil(t, err)
+	require.Empty(t, findings)
+
+	// the h2 probes go through the proxy too
+	var connected string
+	proxy := newConnectProxy(&connected)
+	defer proxy.Close()
+	proxyOptions := DefaultClientOptions()
+	proxyOptions.Proxy = proxy.URL
+	proxied, err := NewClient(proxyOptions, nil)
+	require.Nil(t, err)
+	target := testhttp.CreateH2SmuggleServer(t, true)
+	findings, err = proxied.DetectSmuggling(context.Background(), target, options)
+	require.Nil(t, err)
+	require.Empty(t, findings)
+	require.Equal(t, strings.TrimPrefix(target, "https://"), connected)
+
+	// no h2, nothing can be probed
+	target = testhttp.CreateSmuggleServer(t, testhttp.FramingTE, testhttp.FramingTE)
+	_, err = client.DetectSmuggling(context.Background(), target, options)
+	require.NotNil(t, err)
+}
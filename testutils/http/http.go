@@ -1,10 +1,16 @@
 package http
 
 import (
+	"bufio"
 	"compress/gzip"
+	"crypto/tls"
 	"fmt"
 	"github.com/iami317/logx"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -258,3 +264,175 @@ func CreateRedirectServer(t *testing.T) *httptest.Server {
 
 	return ts
 }
+
+func CreateBrowserRedirectServer(t *testing.T) *httptest.Server {
+	ts := createTestServer(func(w http.ResponseWriter, r *http.Request) {
+		//t.Logf("Method: %v", r.Method)
+		//t.Logf("Path: %v", r.URL.Path)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/meta-refresh":
+			_, _ = w.Write([]byte(`<html><head><META HTTP-EQUIV="Refresh" CONTENT="0; URL='/js-location'"></head></html>`))
+		case "/js-location":
+			_, _ = w.Write([]byte(`<script>window.location.href = "/js-replace";</script>`))
+		case "/js-replace":
+			_, _ = w.Write([]byte(`<script>setTimeout(function(){location.replace('/final')}, 0)</script>`))
+		case "/final":
+			_, _ = w.Write([]byte("browser redirect final page"))
+		case "/self-refresh":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="30">`))
+		case "/loop":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="0;url=/loop-back">`))
+		case "/loop-back":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="0;url=/loop">`))
+		}
+	})
+
+	return ts
+}
+
+// protocolHandler echoes the protocol and body of the request, ?size=n answers n bytes instead
+func protocolHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Header().Set("X-Proto", r.Proto)
+	if size, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil {
+		_, _ = w.Write([]byte(strings.Repeat("a", size)))
+		return
+	}
+	_, _ = w.Write([]byte(fmt.Sprintf("%s %s %s", r.Proto, r.Method, body)))
+}
+
+// CreateHTTP1Server tls server offering http/1.1 only
+func CreateHTTP1Server(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(protocolHandler))
+}
+
+// CreateH2Server tls server negotiating h2 by alpn
+func CreateH2Server(t *testing.T) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(protocolHandler))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+// CreateH2CServer cleartext h2 with prior knowledge, upgrade requests are served over http/1.1
+func CreateH2CServer(t *testing.T) *httptest.Server {
+	h2cHandler := h2c.NewHandler(http.HandlerFunc(protocolHandler), &http2.Server{})
+	return createTestServer(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Upgrade")
+		h2cHandler.ServeHTTP(w, r)
+	})
+}
+
+// CreateH2CUpgradeServer cleartext h2 with prior knowledge or by Upgrade: h2c
+func CreateH2CUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(h2c.NewHandler(http.HandlerFunc(protocolHandler), &http2.Server{}))
+}
+
+// CreateH3Server tls server advertising Alt-Svc: h3 and serving http/3 on the
+// same port over udp, the quic server is closed when the test ends
+func CreateH3Server(t *testing.T) *httptest.Server {
+	h3 := &http3.Server{Handler: http.HandlerFunc(protocolHandler)}
+	var altSvc string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		protocolHandler(w, r)
+	}))
+	conn, err := net.ListenPacket("udp", ts.Listener.Addr().String())
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	altSvc = fmt.Sprintf(`h3=":%d"; ma=3600`, conn.LocalAddr().(*net.UDPAddr).Port)
+	ts.StartTLS()
+	h3.TLSConfig = http3.ConfigureTLSConfig(&tls.Config{Certificates: ts.TLS.Certificates})
+	go func() { _ = h3.Serve(conn) }()
+	t.Cleanup(func() {
+		_ = h3.Close()
+		_ = conn.Close()
+	})
+	return ts
+}
+
+// CreateRaceServer tls server (h2 and http/1.1) with a single use coupon at
+// /redeem, the check and the use are 100ms apart so concurrent requests can
+// redeem it more than once, GET /redeemed answers the number of redemptions
+func CreateRaceServer(t *testing.T) *httptest.Server {
+	var (
+		used      atomic.Bool
+		redeemed  atomic.Int32
+		raceDelay = 100 * time.Millisecond
+	)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redeem":
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != "coupon=SAVE10" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if used.Load() {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte("already redeemed"))
+				return
+			}
+			time.Sleep(raceDelay)
+			used.Store(true)
+			redeemed.Add(1)
+			_, _ = w.Write([]byte("redeemed " + r.Proto))
+		case "/redeemed":
+			_, _ = w.Write([]byte(strconv.Itoa(int(redeemed.Load()))))
+		}
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+// CreatePipelineServer raw http/1.1 server that reads batch requests before it
+// answers any of them and then closes the connection, so only a pipelining
+// client gets answers without waiting for the 1s read timeout. Every response
+// echoes the request path and carries its position on the connection in X-Conn-Request
+func CreatePipelineServer(t *testing.T, batch int) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go servePipeline(conn, batch)
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func servePipeline(conn net.Conn, batch int) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	var paths []string
+	for len(paths) < batch {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			break
+		}
+		_, _ = ioutil.ReadAll(req.Body)
+		paths = append(paths, req.URL.Path)
+	}
+	var out strings.Builder
+	for i, path := range paths {
+		out.WriteString("HTTP/1.1 200 OK\r\n")
+		fmt.Fprintf(&out, "Content-Length: %d\r\nX-Conn-Request: %d\r\n", len(path), i+1)
+		if i == len(paths)-1 {
+			out.WriteString("Connection: close\r\n")
+		}
+		out.WriteString("\r\n" + path)
+	}
+	_, _ = conn.Write([]byte(out.String()))
+}
@@ -0,0 +1,433 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// How a hop of the smuggling servers finds the end of a request body
+const (
+	FramingCL       = "cl"        // Content-Length only, Transfer-Encoding is ignored
+	FramingTE       = "te"        // chunked when any Transfer-Encoding header mentions chunked, Content-Length otherwise
+	FramingTEStrict = "te-strict" // chunked only for a single exact "Transfer-Encoding: chunked" line
+)
+
+// smuggleBackTimeout how long a front waits for the backend before answering 504
+const smuggleBackTimeout = 3 * time.Second
+
+var errMalformedRequest = errors.New("malformed request")
+
+// smuggleRequest a request as one hop parsed it off the connection
+type smuggleRequest struct {
+	raw   []byte // every byte the hop consumed for the request
+	path  string
+	close bool
+}
+
+func readSmuggleRequest(br *bufio.Reader, framing string) (*smuggleRequest, error) {
+	var raw []byte
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		raw = append(raw, line...)
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	requestLine, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(requestLine)
+	if len(parts) != 3 {
+		return nil, errMalformedRequest
+	}
+	req := &smuggleRequest{path: parts[1]}
+
+	var (
+		contentLength int64
+		teLines       []string
+	)
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			if contentLength, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64); err != nil || contentLength < 0 {
+				return nil, errMalformedRequest
+			}
+		case "transfer-encoding":
+			teLines = append(teLines, line)
+		case "connection":
+			req.close = strings.EqualFold(strings.TrimSpace(value), "close")
+		}
+	}
+
+	var chunked bool
+	switch framing {
+	case FramingTE:
+		for _, line := range teLines {
+			_, value, _ := strings.Cut(line, ":")
+			chunked = chunked || strings.Contains(strings.ToLower(value), "chunked")
+		}
+	case FramingTEStrict:
+		chunked = len(teLines) == 1 && strings.EqualFold(teLines[0], "Transfer-Encoding: chunked")
+	}
+	if !chunked {
+		body := make([]byte, contentLength)
+		if _, err = io.ReadFull(br, body); err != nil {
+			return nil, err
+		}
+		req.raw = append(raw, body...)
+		return req, nil
+	}
+	// like real servers, a size line is rejected at its first invalid byte
+	readSize := func() (string, error) {
+		var (
+			line      []byte
+			extension bool
+		)
+		for {
+			b, err := br.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			raw = append(raw, b)
+			if b == '\n' {
+				return strings.TrimRight(string(line), "\r"), nil
+			}
+			extension = extension || b == ';'
+			if !extension && !strings.ContainsRune("0123456789abcdefABCDEF \t\r", rune(b)) {
+				return "", errMalformedRequest
+			}
+			line = append(line, b)
+		}
+	}
+	for {
+		line, err := readSize()
+		if err != nil {
+			return nil, err
+		}
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return nil, errMalformedRequest
+		}
+		if size == 0 {
+			break
+		}
+		// the data and its crlf
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		raw = append(raw, data...)
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			break
+		}
+	}
+	req.raw = raw
+	return req, nil
+}
+
+func writeSmuggleResponse(w io.Writer, status int, body string) {
+	_, _ = fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Length: %d\r\n\r\n%s", status, http.StatusText(status), len(body), body)
+}
+
+// serveSmuggleBack answers / with 200 and any other path with 404 until the
+// connection breaks
+func serveSmuggleBack(conn net.Conn, framing string) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		req, err := readSmuggleRequest(br, framing)
+		if err != nil {
+			if errors.Is(err, errMalformedRequest) {
+				writeSmuggleResponse(conn, http.StatusBadRequest, "bad request")
+			}
+			return
+		}
+		if req.path == "/" {
+			writeSmuggleResponse(conn, http.StatusOK, "ok")
+		} else {
+			writeSmuggleResponse(conn, http.StatusNotFound, "not found")
+		}
+		if req.close {
+			return
+		}
+	}
+}
+
+// smuggleFront forwards requests byte for byte over pooled backend
+// connections, a connection left with unread bytes poisons the next request
+type smuggleFront struct {
+	backAddr string
+	idle     chan *smuggleBackConn
+}
+
+type smuggleBackConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func newSmuggleFront(t *testing.T, back string) *smuggleFront {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSmuggleBack(conn, back)
+		}
+	}()
+	return &smuggleFront{backAddr: l.Addr().String(), idle: make(chan *smuggleBackConn, 1)}
+}
+
+// roundTrip status and body of the backend's answer to raw
+func (f *smuggleFront) roundTrip(raw []byte) (int, []byte) {
+	var back *smuggleBackConn
+	select {
+	case back = <-f.idle:
+	default:
+		conn, err := net.Dial("tcp", f.backAddr)
+		if err != nil {
+			return http.StatusBadGateway, nil
+		}
+		back = &smuggleBackConn{Conn: conn, br: bufio.NewReader(conn)}
+	}
+	_ = back.SetDeadline(time.Now().Add(smuggleBackTimeout))
+	if _, err := back.Write(raw); err != nil {
+		back.Close()
+		return http.StatusBadGateway, nil
+	}
+	resp, err := http.ReadResponse(back.br, nil)
+	if err != nil {
+		back.Close()
+		return http.StatusGatewayTimeout, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.Close {
+		back.Close()
+	} else {
+		select {
+		case f.idle <- back:
+		default:
+			back.Close()
+		}
+	}
+	return resp.StatusCode, body
+}
+
+// CreateSmuggleServer http/1.1 front proxy and its backend, each finding the
+// end of a request body by its framing (FramingCL and friends). The front
+// forwards every request as the bytes it parsed over a pool of one keep-alive
+// backend connection, so different framings desync them: cl/te is vulnerable to
+// CL.TE, te/cl to TE.CL, te-strict/te to TE.TE and te/te is safe. The backend
+// answers / with 200 and anything else with 404. Returns the url of the front.
+func CreateSmuggleServer(t *testing.T, front, back string) string {
+	f := newSmuggleFront(t, back)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					req, err := readSmuggleRequest(br, front)
+					if err != nil {
+						if errors.Is(err, errMalformedRequest) {
+							writeSmuggleResponse(conn, http.StatusBadRequest, "bad request")
+						}
+						return
+					}
+					status, body := f.roundTrip(req.raw)
+					writeSmuggleResponse(conn, status, string(body))
+					if req.close {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+// CreateH2SmuggleServer tls h2 front downgrading requests to http/1.1 for a
+// backend honoring Transfer-Encoding (FramingTE). The front copies content-length
+// and transfer-encoding of the h2 request as they are, vulnerable to H2.CL and
+// H2.TE, unless validate makes it reject them. Returns the url of the front.
+func CreateH2SmuggleServer(t *testing.T, validate bool) string {
+	f := newSmuggleFront(t, FramingTE)
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	certificates := ts.TLS.Certificates
+	ts.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates, NextProtos: []string{http2.NextProtoTLS}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveH2SmuggleFront(conn, f, validate)
+		}
+	}()
+	return "https://" + l.Addr().String()
+}
+
+type h2SmuggleStream struct {
+	headers []hpack.HeaderField
+	data    []byte
+}
+
+func serveH2SmuggleFront(conn net.Conn, f *smuggleFront, validate bool) {
+	defer conn.Close()
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil || string(preface) != http2.ClientPreface {
+		return
+	}
+	framer := http2.NewFramer(conn, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := framer.WriteSettings(); err != nil {
+		return
+	}
+	streams := make(map[uint32]*h2SmuggleStream)
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return
+		}
+		var (
+			id    = frame.Header().StreamID
+			ended bool
+		)
+		switch frame := frame.(type) {
+		case *http2.SettingsFrame:
+			if !frame.IsAck() {
+				_ = framer.WriteSettingsAck()
+			}
+			continue
+		case *http2.PingFrame:
+			if !frame.IsAck() {
+				_ = framer.WritePing(true, frame.Data)
+			}
+			continue
+		case *http2.MetaHeadersFrame:
+			streams[id] = &h2SmuggleStream{headers: frame.Fields}
+			ended = frame.StreamEnded()
+		case *http2.DataFrame:
+			stream, ok := streams[id]
+			if !ok {
+				continue
+			}
+			stream.data = append(stream.data, frame.Data()...)
+			ended = frame.StreamEnded()
+			if len(frame.Data()) > 0 {
+				_ = framer.WriteWindowUpdate(0, uint32(len(frame.Data())))
+			}
+		case *http2.GoAwayFrame:
+			return
+		default:
+			continue
+		}
+		if !ended {
+			continue
+		}
+		status, body := http.StatusBadRequest, []byte("bad request")
+		if raw, ok := downgradeH2Request(streams[id], validate); ok {
+			status, body = f.roundTrip(raw)
+		}
+		delete(streams, id)
+
+		var block bytes.Buffer
+		encoder := hpack.NewEncoder(&block)
+		_ = encoder.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+		_ = encoder.WriteField(hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(body))})
+		if err = framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block.Bytes(), EndHeaders: true}); err != nil {
+			return
+		}
+		if err = framer.WriteData(id, true, body); err != nil {
+			return
+		}
+	}
+}
+
+// downgradeH2Request http/1.1 form of the stream, false when a validating front rejects it
+func downgradeH2Request(stream *h2SmuggleStream, validate bool) ([]byte, bool) {
+	var (
+		method, path, authority string
+		headers                 strings.Builder
+		hasLength               bool
+	)
+	for _, field := range stream.headers {
+		switch field.Name {
+		case ":method":
+			method = field.Value
+		case ":path":
+			path = field.Value
+		case ":authority":
+			authority = field.Value
+		case ":scheme":
+		case "content-length":
+			if validate && field.Value != strconv.Itoa(len(stream.data)) {
+				return nil, false
+			}
+			hasLength = true
+			headers.WriteString("Content-Length: " + field.Value + "\r\n")
+		case "transfer-encoding":
+			if validate {
+				return nil, false
+			}
+			headers.WriteString("Transfer-Encoding: " + field.Value + "\r\n")
+		default:
+			headers.WriteString(field.Name + ": " + field.Value + "\r\n")
+		}
+	}
+	if !hasLength && len(stream.data) > 0 {
+		headers.WriteString("Content-Length: " + strconv.Itoa(len(stream.data)) + "\r\n")
+	}
+	raw := method + " " + path + " HTTP/1.1\r\nHost: " + authority + "\r\n" + headers.String() + "\r\n"
+	return append([]byte(raw), stream.data...), true
+}
@@ -1,6 +1,9 @@
 package tcp
 
-import "net"
+import (
+	"errors"
+	"net"
+)
 
 // TCPServer creates a new tcp server that returns a response
 type TCPServer struct {
@@ -23,6 +26,9 @@ func NewTCPServer(handler func(conn net.Conn)) *TCPServer {
 		for {
 			// Listen for an incoming connection.
 			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
 				continue
 			}
@@ -0,0 +1,96 @@
+package shttp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/iami317/shttp/xtls"
+	"time"
+)
+
+// TLSInfo negotiated parameters of the connection a response was received on
+type TLSInfo struct {
+	Version      string             `json:"version"`
+	CipherSuite  string             `json:"cipher_suite"`
+	ALPN         string             `json:"alpn"`
+	ServerName   string             `json:"server_name"` // sni sent, empty for ip targets
+	OCSPResponse []byte             `json:"ocsp_response"`
+	DidResume    bool               `json:"did_resume"`
+	Certificates []*CertificateInfo `json:"certificates"` // peer chain, leaf first
+
+	State tls.ConnectionState `json:"-"`
+}
+
+// CertificateInfo summary of a peer certificate
+type CertificateInfo struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	CommonName         string    `json:"common_name"`
+	DNSNames           []string  `json:"dns_names"`
+	IPAddresses        []string  `json:"ip_addresses"`
+	EmailAddresses     []string  `json:"email_addresses"`
+	SerialNumber       string    `json:"serial_number"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	IsCA               bool      `json:"is_ca"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	SHA256             string    `json:"sha256"`
+
+	Certificate *x509.Certificate `json:"-"`
+}
+
+// ExpiresIn time left until NotAfter, negative when already expired
+func (c *CertificateInfo) ExpiresIn() time.Duration {
+	return time.Until(c.NotAfter)
+}
+
+// Expired outside the validity period
+func (c *CertificateInfo) Expired() bool {
+	now := time.Now()
+	return now.Before(c.NotBefore) || now.After(c.NotAfter)
+}
+
+func newTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	info := &TLSInfo{
+		Version:      xtls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ALPN:         state.NegotiatedProtocol,
+		ServerName:   state.ServerName,
+		OCSPResponse: state.OCSPResponse,
+		DidResume:    state.DidResume,
+		State:        *state,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.Certificates = append(info.Certificates, newCertificateInfo(cert))
+	}
+	return info
+}
+
+func newCertificateInfo(cert *x509.Certificate) *CertificateInfo {
+	sum := sha256.Sum256(cert.Raw)
+	info := &CertificateInfo{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		CommonName:         cert.Subject.CommonName,
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		IsCA:               cert.IsCA,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		SHA256:             hex.EncodeToString(sum[:]),
+		Certificate:        cert,
+	}
+	if cert.SerialNumber != nil {
+		info.SerialNumber = fmt.Sprintf("%X", cert.SerialNumber)
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
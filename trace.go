@@ -54,6 +54,7 @@ type clientTrace struct {
 	gotFirstResponseByte time.Time
 	endTime              time.Time
 	gotConnInfo          httptrace.GotConnInfo
+	tlsState             *tls.ConnectionState
 }
 
 //‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾‾
@@ -83,6 +84,8 @@ func (t *clientTrace) createContext(ctx context.Context) context.Context {
 			},
 			GetConn: func(_ string) {
 				t.getConn = time.Now()
+				// a reused connection has no handshake, don't report the one of a previous redirect hop
+				t.tlsState = nil
 			},
 			GotConn: func(ci httptrace.GotConnInfo) {
 				t.gotConn = time.Now()
@@ -94,8 +97,11 @@ func (t *clientTrace) createContext(ctx context.Context) context.Context {
 			TLSHandshakeStart: func() {
 				t.tlsHandshakeStart = time.Now()
 			},
-			TLSHandshakeDone: func(_ tls.ConnectionState, _ error) {
+			TLSHandshakeDone: func(state tls.ConnectionState, err error) {
 				t.tlsHandshakeDone = time.Now()
+				if err == nil {
+					t.tlsState = &state
+				}
 			},
 		},
 	)
@@ -0,0 +1,183 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/youmark/pkcs8"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+type serverNameKey struct{}
+
+// ContextWithServerName ctx for requests whose handshake needs the target host
+// to select a client certificate. fn is called during the handshake, so it
+// can follow redirects to other hosts.
+func ContextWithServerName(ctx context.Context, fn func() string) context.Context {
+	return context.WithValue(ctx, serverNameKey{}, fn)
+}
+
+func serverNameFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if fn, ok := ctx.Value(serverNameKey{}).(func() string); ok {
+		return fn()
+	}
+	return ""
+}
+
+func loadRootCAs(options *ClientOptions) (*x509.CertPool, error) {
+	if len(options.RootCAFiles) == 0 && len(options.RootCAPEM) == 0 {
+		return nil, nil
+	}
+	pool := x509.NewCertPool()
+	if !options.NoSystemRoots {
+		if systemPool, err := x509.SystemCertPool(); err == nil {
+			pool = systemPool
+		}
+	}
+	for _, file := range options.RootCAFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in root ca file %s", file)
+		}
+	}
+	if len(options.RootCAPEM) > 0 && !pool.AppendCertsFromPEM(options.RootCAPEM) {
+		return nil, errors.New("no certificate found in root ca pem")
+	}
+	return pool, nil
+}
+
+func loadCertificate(c CertConfig) (*tls.Certificate, error) {
+	var err error
+	certPEM := c.CertPEM
+	if certPEM == nil {
+		if certPEM, err = ioutil.ReadFile(c.CertFile); err != nil {
+			return nil, err
+		}
+	}
+	keyPEM := c.KeyPEM
+	if keyPEM == nil {
+		if c.KeyFile == "" {
+			keyPEM = certPEM
+		} else if keyPEM, err = ioutil.ReadFile(c.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if keyPEM, err = decryptPEMKey(keyPEM, c.KeyPassword); err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// decryptPEMKey returns the first private key block of data in clear, both
+// PKCS#8 "ENCRYPTED PRIVATE KEY" and legacy Proc-Type encrypted blocks are supported
+func decryptPEMKey(data []byte, password string) ([]byte, error) {
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no private key found in pem")
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if password == "" {
+				return nil, errors.New("private key is encrypted, password required")
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("decrypt private key: %v", err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+		}
+		if x509.IsEncryptedPEMBlock(block) { // nolint[:staticcheck]
+			if password == "" {
+				return nil, errors.New("private key is encrypted, password required")
+			}
+			der, err := x509.DecryptPEMBlock(block, []byte(password)) // nolint[:staticcheck]
+			if err != nil {
+				return nil, fmt.Errorf("decrypt private key: %v", err)
+			}
+			return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+		}
+		return pem.EncodeToMemory(block), nil
+	}
+}
+
+type certEntry struct {
+	cert  *tls.Certificate
+	hosts []string
+}
+
+// certSelector picks the client certificate by target host, then by the CAs
+// the server asks for
+type certSelector struct {
+	entries []certEntry
+}
+
+func (s *certSelector) getClientCertificate(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.selectCertificate(serverNameFromContext(cri.Context()), cri)
+}
+
+func (s *certSelector) selectCertificate(host string, cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	host = strings.ToLower(host)
+	var dedicated, fallback []*tls.Certificate
+	for _, entry := range s.entries {
+		if len(entry.hosts) == 0 {
+			fallback = append(fallback, entry.cert)
+			continue
+		}
+		if host != "" && matchHost(entry.hosts, host) {
+			dedicated = append(dedicated, entry.cert)
+		}
+	}
+	for _, candidates := range [][]*tls.Certificate{dedicated, fallback} {
+		for _, cert := range candidates {
+			if cri.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[0], nil
+		}
+	}
+	// no certificate, the server decides whether that is acceptable
+	return &tls.Certificate{}, nil
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == host {
+			return true
+		}
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
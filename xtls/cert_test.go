@@ -0,0 +1,140 @@
+package xtls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/require"
+	"github.com/youmark/pkcs8"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert self signed when parent is nil
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestLoadRootCAs(t *testing.T) {
+	options := DefaultClientOptions()
+	options.RootCAFiles = []string{"../testutils/.testdata/sample-root.pem"}
+	options.NoSystemRoots = true
+	config, err := NewTLSConfig(options)
+	require.Nil(t, err)
+	require.NotNil(t, config.RootCAs)
+
+	options.RootCAFiles = []string{"../testutils/.testdata/text-file.txt"}
+	_, err = NewTLSConfig(options)
+	require.NotNil(t, err)
+}
+
+func TestLoadCertificate_Encrypted(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	client := newTestCert(t, "client", ca, false)
+
+	der, err := pkcs8.MarshalPrivateKey(client.key, []byte("secret"), nil)
+	require.Nil(t, err)
+	encrypted := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der})
+
+	cert, err := loadCertificate(CertConfig{CertPEM: client.certPEM, KeyPEM: encrypted, KeyPassword: "secret"})
+	require.Nil(t, err)
+	require.Equal(t, "client", cert.Leaf.Subject.CommonName)
+
+	_, err = loadCertificate(CertConfig{CertPEM: client.certPEM, KeyPEM: encrypted, KeyPassword: "wrong"})
+	require.NotNil(t, err)
+	_, err = loadCertificate(CertConfig{CertPEM: client.certPEM, KeyPEM: encrypted})
+	require.NotNil(t, err)
+
+	// cert and key in one pem
+	combined := append(append([]byte{}, client.certPEM...), client.keyPEM...)
+	cert, err = loadCertificate(CertConfig{CertPEM: combined})
+	require.Nil(t, err)
+	require.Equal(t, "client", cert.Leaf.Subject.CommonName)
+}
+
+func TestClientCertificate_PerHost(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	hostCert := newTestCert(t, "a.example.com", ca, false)
+	defaultCert := newTestCert(t, "default", ca, false)
+
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.Nil(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.TLSSkipVerify = false
+	options.RootCAPEM = ca.certPEM
+	options.Certificates = []CertConfig{
+		{CertPEM: defaultCert.certPEM, KeyPEM: defaultCert.keyPEM},
+		{CertPEM: hostCert.certPEM, KeyPEM: hostCert.keyPEM, Hosts: []string{"*.example.com"}},
+	}
+	config, err := NewTLSConfig(options)
+	require.Nil(t, err)
+
+	for host, want := range map[string]string{"a.example.com": "a.example.com", "other.test": "default"} {
+		hc := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		host := host
+		ctx := ContextWithServerName(context.Background(), func() string { return host })
+		hr, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		resp, err := hc.Do(hr)
+		require.Nil(t, err)
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		resp.Body.Close()
+		require.Equal(t, want, string(body[:n]))
+	}
+}
@@ -1,47 +1,126 @@
 package xtls
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"software.sslmate.com/src/go-pkcs12"
 )
 
+var (
+	// ErrPKCS12Password the PKCS#12 bundle could not be decrypted with the given password
+	ErrPKCS12Password = errors.New("pkcs12: incorrect password")
+	// ErrPKCS12Corrupt the PKCS#12 bundle is malformed or uses an unsupported format
+	ErrPKCS12Corrupt = errors.New("pkcs12: corrupt or unsupported file")
+)
+
 func parsePKCS12FromFile(c PKCS12Config) (*tls.Certificate, error) {
-	data, err := ioutil.ReadFile(c.Path)
-	if err != nil {
-		return nil, err
+	data := c.Data
+	if data == nil {
+		var err error
+		if data, err = ioutil.ReadFile(c.Path); err != nil {
+			return nil, err
+		}
 	}
 
-	privateKey, certificate, _, err := pkcs12.DecodeChain(data, c.Password)
+	// an empty password is valid, openssl -passout pass: bundles use it
+	privateKey, certificate, caCerts, err := pkcs12.DecodeChain(data, c.Password)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) || errors.Is(err, pkcs12.ErrDecryption) {
+			return nil, fmt.Errorf("%w %s", ErrPKCS12Password, c.Path)
+		}
+		return nil, fmt.Errorf("%w %s: %v", ErrPKCS12Corrupt, c.Path, err)
 	}
 	return &tls.Certificate{
-		Certificate: [][]byte{certificate.Raw},
+		Certificate: buildChain(certificate, caCerts),
 		PrivateKey:  privateKey,
 		Leaf:        certificate,
 	}, nil
 }
 
+// buildChain leaf first, then each issuer found in caCerts, remaining certificates last
+func buildChain(leaf *x509.Certificate, caCerts []*x509.Certificate) [][]byte {
+	chain := [][]byte{leaf.Raw}
+	used := make([]bool, len(caCerts))
+	current := leaf
+	for {
+		next := -1
+		for i, ca := range caCerts {
+			if !used[i] && !bytes.Equal(ca.Raw, current.Raw) && bytes.Equal(ca.RawSubject, current.RawIssuer) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		used[next] = true
+		chain = append(chain, caCerts[next].Raw)
+		current = caCerts[next]
+	}
+	for i, ca := range caCerts {
+		if !used[i] && !bytes.Equal(ca.Raw, leaf.Raw) {
+			chain = append(chain, ca.Raw)
+		}
+	}
+	return chain
+}
+
 func NewTLSConfig(options *ClientOptions) (*tls.Config, error) {
+	config, _, err := newTLSConfig(options)
+	return config, err
+}
+
+// newTLSConfig the selector is nil when no client certificate is configured
+func newTLSConfig(options *ClientOptions) (*tls.Config, *certSelector, error) {
 	var err error
 	var cert *tls.Certificate
 
-	if options.PKCS12.Path != "" && options.PKCS12.Password != "" {
+	if options.PKCS12.Path != "" || len(options.PKCS12.Data) > 0 {
 		cert, err = parsePKCS12FromFile(options.PKCS12)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
+	rootCAs, err := loadRootCAs(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verifier, err := NewVerifier(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	tlsClientConfig := &tls.Config{
-		InsecureSkipVerify: options.TLSSkipVerify,
+		InsecureSkipVerify: options.TLSSkipVerify || options.TLSVerifyReportOnly,
 		MinVersion:         options.TLSMinVersion,
 		MaxVersion:         options.TLSMaxVersion,
+		RootCAs:            rootCAs,
+	}
+	// report only: the result is computed per response by the caller
+	if verifier != nil && !verifier.ReportOnly() {
+		tlsClientConfig.VerifyConnection = verifier.verifyConnection
 	}
 
+	selector := &certSelector{}
 	if cert != nil {
-		tlsClientConfig.Certificates = []tls.Certificate{*cert}
+		selector.entries = append(selector.entries, certEntry{cert: cert})
+	}
+	for _, c := range options.Certificates {
+		pemCert, err := loadCertificate(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		selector.entries = append(selector.entries, certEntry{cert: pemCert, hosts: c.Hosts})
+	}
+	if len(selector.entries) == 0 {
+		return tlsClientConfig, nil, nil
 	}
-	return tlsClientConfig, nil
+	tlsClientConfig.GetClientCertificate = selector.getClientCertificate
+	return tlsClientConfig, selector, nil
 }
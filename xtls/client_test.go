@@ -0,0 +1,46 @@
+package xtls
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParsePKCS12_Chain(t *testing.T) {
+	for _, c := range []PKCS12Config{
+		{Path: "../testutils/.testdata/client-chain.p12", Password: "secret"},
+		{Path: "../testutils/.testdata/client-nopass.p12"},
+	} {
+		cert, err := parsePKCS12FromFile(c)
+		require.Nil(t, err, c.Path)
+		require.Equal(t, "shttp test client", cert.Leaf.Subject.CommonName)
+		require.Len(t, cert.Certificate, 3)
+		intermediate, err := x509.ParseCertificate(cert.Certificate[1])
+		require.Nil(t, err)
+		require.Equal(t, "shttp test intermediate", intermediate.Subject.CommonName)
+		root, err := x509.ParseCertificate(cert.Certificate[2])
+		require.Nil(t, err)
+		require.Equal(t, "shttp test root", root.Subject.CommonName)
+	}
+
+	options := DefaultClientOptions()
+	options.PKCS12.Path = "../testutils/.testdata/client-nopass.p12"
+	config, err := NewTLSConfig(options)
+	require.Nil(t, err)
+	require.NotNil(t, config.GetClientCertificate)
+
+	data, err := ioutil.ReadFile("../testutils/.testdata/client-chain.p12")
+	require.Nil(t, err)
+	_, err = parsePKCS12FromFile(PKCS12Config{Data: data, Password: "secret"})
+	require.Nil(t, err)
+}
+
+func TestParsePKCS12_Errors(t *testing.T) {
+	_, err := parsePKCS12FromFile(PKCS12Config{Path: "../testutils/.testdata/client-chain.p12", Password: "wrong"})
+	require.True(t, errors.Is(err, ErrPKCS12Password), "%v", err)
+
+	_, err = parsePKCS12FromFile(PKCS12Config{Path: "../testutils/.testdata/text-file.txt", Password: "secret"})
+	require.True(t, errors.Is(err, ErrPKCS12Corrupt), "%v", err)
+}
@@ -0,0 +1,340 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	utls "github.com/refraction-networking/utls"
+	"net"
+)
+
+// ClientHello profiles
+const (
+	ClientHelloGo         = "go" // crypto/tls, the default
+	ClientHelloChrome     = "chrome"
+	ClientHelloFirefox    = "firefox"
+	ClientHelloSafari     = "safari"
+	ClientHelloEdge       = "edge"
+	ClientHelloIOS        = "ios"
+	ClientHelloRandomized = "randomized"
+	ClientHelloCustom     = "custom" // ClientOptions.ClientHelloSpec
+)
+
+var clientHelloIDs = map[string]utls.ClientHelloID{
+	ClientHelloChrome:     utls.HelloChrome_Auto,
+	ClientHelloFirefox:    utls.HelloFirefox_Auto,
+	ClientHelloSafari:     utls.HelloSafari_Auto,
+	ClientHelloEdge:       utls.HelloEdge_Auto,
+	ClientHelloIOS:        utls.HelloIOS_Auto,
+	ClientHelloRandomized: utls.HelloRandomized,
+}
+
+// GREASEPlaceholder in ClientHelloSpec cipher suites, extensions or curves is replaced by a random GREASE value
+const GREASEPlaceholder = utls.GREASE_PLACEHOLDER
+
+// ClientHelloSpec custom ClientHello, zero fields use the defaults below
+type ClientHelloSpec struct {
+	MinVersion          uint16   `json:"min_version" yaml:"min_version"`
+	MaxVersion          uint16   `json:"max_version" yaml:"max_version"`
+	CipherSuites        []uint16 `json:"cipher_suites" yaml:"cipher_suites"`
+	Extensions          []uint16 `json:"extensions" yaml:"extensions"` // extension ids in the order sent
+	Curves              []uint16 `json:"curves" yaml:"curves"`
+	PointFormats        []uint8  `json:"point_formats" yaml:"point_formats"`
+	SignatureAlgorithms []uint16 `json:"signature_algorithms" yaml:"signature_algorithms"`
+	ALPN                []string `json:"alpn" yaml:"alpn"`
+}
+
+// Clone custom ClientHello spec
+func (s *ClientHelloSpec) Clone() *ClientHelloSpec {
+	newSpec := *s
+	newSpec.CipherSuites = append([]uint16(nil), s.CipherSuites...)
+	newSpec.Extensions = append([]uint16(nil), s.Extensions...)
+	newSpec.Curves = append([]uint16(nil), s.Curves...)
+	newSpec.PointFormats = append([]uint8(nil), s.PointFormats...)
+	newSpec.SignatureAlgorithms = append([]uint16(nil), s.SignatureAlgorithms...)
+	newSpec.ALPN = append([]string(nil), s.ALPN...)
+	return &newSpec
+}
+
+var (
+	defaultSpecSuites = []uint16{
+		tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	}
+	defaultSpecExtensions = []uint16{
+		extServerName, extExtendedMasterSec, extRenegotiationInfo, extSupportedGroups, extECPointFormats,
+		extSessionTicket, extALPN, extStatusRequest, extSignatureAlgorithms, extSCT, extKeyShare,
+		extPSKModes, extSupportedVersions,
+	}
+	defaultSpecCurves = []uint16{groupX25519, 0x0017, 0x0018}
+)
+
+const (
+	extStatusRequest = 0x0005
+	extALPN          = 0x0010
+	extSCT           = 0x0012
+	extPadding       = 0x0015
+	extSessionTicket = 0x0023
+	extPSKModes      = 0x002d
+)
+
+// CustomClientHello options replace the crypto/tls ClientHello
+func (o *ClientOptions) CustomClientHello() bool {
+	return o.ClientHello != "" && o.ClientHello != ClientHelloGo
+}
+
+// clientHelloSpec utls spec of the configured profile, alpn is reduced to
+// http/1.1 for browser profiles since net/http can't speak h2 over a utls conn
+func (o *ClientOptions) clientHelloSpec() (*utls.ClientHelloSpec, error) {
+	if o.ClientHello == ClientHelloCustom {
+		if o.ClientHelloSpec == nil {
+			return nil, fmt.Errorf("client hello %s needs a ClientHelloSpec", ClientHelloCustom)
+		}
+		return o.ClientHelloSpec.utlsSpec(), nil
+	}
+	id, ok := clientHelloIDs[o.ClientHello]
+	if !ok {
+		return nil, fmt.Errorf("unknown client hello profile %s", o.ClientHello)
+	}
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+	return &spec, nil
+}
+
+func (s *ClientHelloSpec) utlsSpec() *utls.ClientHelloSpec {
+	suites, extensions, curves := s.CipherSuites, s.Extensions, s.Curves
+	if len(suites) == 0 {
+		suites = defaultSpecSuites
+	}
+	if len(extensions) == 0 {
+		extensions = defaultSpecExtensions
+	}
+	if len(curves) == 0 {
+		curves = defaultSpecCurves
+	}
+	pointFormats := s.PointFormats
+	if len(pointFormats) == 0 {
+		pointFormats = []uint8{0}
+	}
+	sigAlgs := s.SignatureAlgorithms
+	if len(sigAlgs) == 0 {
+		sigAlgs = helloSignatureAlgs
+	}
+	alpn := s.ALPN
+	if len(alpn) == 0 {
+		alpn = []string{"http/1.1"}
+	}
+	minVersion, maxVersion := s.MinVersion, s.MaxVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS10
+	}
+	if maxVersion == 0 {
+		maxVersion = tls.VersionTLS13
+	}
+
+	spec := &utls.ClientHelloSpec{
+		CipherSuites:       suites,
+		CompressionMethods: []uint8{0},
+		TLSVersMin:         minVersion,
+		TLSVersMax:         maxVersion,
+	}
+	var curveIDs []utls.CurveID
+	for _, c := range curves {
+		curveIDs = append(curveIDs, utls.CurveID(c))
+	}
+	for _, id := range extensions {
+		var ext utls.TLSExtension
+		switch id {
+		case extServerName:
+			ext = &utls.SNIExtension{}
+		case extStatusRequest:
+			ext = &utls.StatusRequestExtension{}
+		case extSupportedGroups:
+			ext = &utls.SupportedCurvesExtension{Curves: curveIDs}
+		case extECPointFormats:
+			ext = &utls.SupportedPointsExtension{SupportedPoints: pointFormats}
+		case extSignatureAlgorithms:
+			schemes := make([]utls.SignatureScheme, 0, len(sigAlgs))
+			for _, alg := range sigAlgs {
+				schemes = append(schemes, utls.SignatureScheme(alg))
+			}
+			ext = &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: schemes}
+		case extALPN:
+			ext = &utls.ALPNExtension{AlpnProtocols: alpn}
+		case extSCT:
+			ext = &utls.SCTExtension{}
+		case extPadding:
+			ext = &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle}
+		case extExtendedMasterSec:
+			ext = &utls.ExtendedMasterSecretExtension{}
+		case extSessionTicket:
+			ext = &utls.SessionTicketExtension{}
+		case extSupportedVersions:
+			var versions []uint16
+			for v := maxVersion; v >= minVersion && v >= tls.VersionTLS10; v-- {
+				versions = append(versions, v)
+			}
+			ext = &utls.SupportedVersionsExtension{Versions: versions}
+		case extPSKModes:
+			ext = &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}}
+		case extKeyShare:
+			group := curveIDs[0]
+			if group == utls.GREASE_PLACEHOLDER && len(curveIDs) > 1 {
+				group = curveIDs[1]
+			}
+			ext = &utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: group}}}
+		case extRenegotiationInfo:
+			ext = &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}
+		case GREASEPlaceholder:
+			ext = &utls.UtlsGREASEExtension{}
+		default:
+			ext = &utls.GenericExtension{Id: id}
+		}
+		spec.Extensions = append(spec.Extensions, ext)
+	}
+	return spec
+}
+
+// DialTLSFunc http.Transport.DialTLSContext
+type DialTLSFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NewTLSDialer dials addr with dial and handshakes with the configured ClientHello,
+// nil when options keep the crypto/tls ClientHello. Connections through an http
+// proxy are handshaked by net/http and keep the crypto/tls ClientHello.
+func NewTLSDialer(options *ClientOptions, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (DialTLSFunc, error) {
+	if !options.CustomClientHello() {
+		return nil, nil
+	}
+	// validate the profile once, a spec is built per connection since utls mutates it
+	if _, err := options.clientHelloSpec(); err != nil {
+		return nil, err
+	}
+	config, selector, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := NewVerifier(options)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		uconn, err := newUConn(ctx, conn, host, options, config, verifier.VerifyConnectionFor(host), selector)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err = uconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if p := uconn.UConn.ConnectionState().NegotiatedProtocol; p != "" && p != "http/1.1" {
+			conn.Close()
+			return nil, fmt.Errorf("tls: server negotiated %s, only http/1.1 is supported with a custom client hello", p)
+		}
+		return uconn, nil
+	}, nil
+}
+
+// Conn utls connection, ConnectionState is converted to crypto/tls
+type Conn struct {
+	*utls.UConn
+}
+
+// ConnectionState crypto/tls view of the utls connection state
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return convertConnectionState(c.UConn.ConnectionState())
+}
+
+// newUConn verify replaces the VerifyConnection of config when not nil, the
+// NextProtos of config replace the protocols of the ALPN extension when set
+func newUConn(ctx context.Context, conn net.Conn, host string, options *ClientOptions, config *tls.Config, verify func(tls.ConnectionState) error, selector *certSelector) (*Conn, error) {
+	spec, err := options.clientHelloSpec()
+	if err != nil {
+		return nil, err
+	}
+	if len(config.NextProtos) > 0 {
+		for _, ext := range spec.Extensions {
+			if alpn, ok := ext.(*utls.ALPNExtension); ok {
+				alpn.AlpnProtocols = config.NextProtos
+			}
+		}
+	}
+	uconfig := &utls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		RootCAs:            config.RootCAs,
+		MinVersion:         config.MinVersion,
+		MaxVersion:         config.MaxVersion,
+		OmitEmptyPsk:       true,
+	}
+	if verify == nil {
+		verify = config.VerifyConnection
+	}
+	if verify != nil {
+		uconfig.VerifyConnection = func(cs utls.ConnectionState) error {
+			return verify(convertConnectionState(cs))
+		}
+	}
+	if selector != nil {
+		if name := serverNameFromContext(ctx); name != "" {
+			host = name
+		}
+		uconfig.GetClientCertificate = func(cri *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+			schemes := make([]tls.SignatureScheme, 0, len(cri.SignatureSchemes))
+			for _, scheme := range cri.SignatureSchemes {
+				schemes = append(schemes, tls.SignatureScheme(scheme))
+			}
+			cert, err := selector.selectCertificate(host, &tls.CertificateRequestInfo{
+				AcceptableCAs:    cri.AcceptableCAs,
+				SignatureSchemes: schemes,
+				Version:          cri.Version,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &utls.Certificate{Certificate: cert.Certificate, PrivateKey: cert.PrivateKey, Leaf: cert.Leaf}, nil
+		}
+	}
+
+	uconn := utls.UClient(conn, uconfig, utls.HelloCustom)
+	if err = uconn.ApplyPreset(spec); err != nil {
+		return nil, err
+	}
+	return &Conn{UConn: uconn}, nil
+}
+
+func convertConnectionState(cs utls.ConnectionState) tls.ConnectionState {
+	return tls.ConnectionState{
+		Version:                     cs.Version,
+		HandshakeComplete:           cs.HandshakeComplete,
+		DidResume:                   cs.DidResume,
+		CipherSuite:                 cs.CipherSuite,
+		NegotiatedProtocol:          cs.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  cs.NegotiatedProtocolIsMutual,
+		ServerName:                  cs.ServerName,
+		PeerCertificates:            cs.PeerCertificates,
+		VerifiedChains:              cs.VerifiedChains,
+		SignedCertificateTimestamps: cs.SignedCertificateTimestamps,
+		OCSPResponse:                cs.OCSPResponse,
+	}
+}
@@ -0,0 +1,83 @@
+package xtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientHelloFingerprint_Custom(t *testing.T) {
+	options := DefaultClientOptions()
+	options.ClientHello = ClientHelloCustom
+	options.ClientHelloSpec = &ClientHelloSpec{
+		CipherSuites:        []uint16{GREASEPlaceholder, 0xc02b, 0x1301},
+		Extensions:          []uint16{GREASEPlaceholder, 0, 10, 11, 13, 16, 43, 51},
+		Curves:              []uint16{29, 23},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804},
+	}
+	fp, err := ClientHelloFingerprint(options, "example.com")
+	require.Nil(t, err)
+	require.Equal(t, "771,49195-4865,0-10-11-13-16-43-51,29-23,0", fp.JA3)
+
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])[:12]
+	}
+	require.Equal(t, "t13d0207h1_"+hash("1301,c02b")+"_"+hash("000a,000b,000d,002b,0033_0403,0804"), fp.JA4)
+}
+
+func TestClientHelloFingerprint_Profiles(t *testing.T) {
+	goFP, err := ClientHelloFingerprint(DefaultClientOptions(), "example.com")
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(goFP.JA4, "t13d"), goFP.JA4)
+
+	options := DefaultClientOptions()
+	options.ClientHello = ClientHelloChrome
+	chrome1, err := ClientHelloFingerprint(options, "example.com")
+	require.Nil(t, err)
+	chrome2, err := ClientHelloFingerprint(options, "example.com")
+	require.Nil(t, err)
+	require.Equal(t, chrome1.JA4, chrome2.JA4, "ja4 sorts the shuffled extensions")
+	require.NotEqual(t, goFP.JA4, chrome1.JA4)
+	require.True(t, strings.HasPrefix(chrome1.JA4, "t13d"), chrome1.JA4)
+	require.Contains(t, chrome1.JA4, "h1_", "alpn is reduced to http/1.1")
+
+	options.ClientHello = "netscape"
+	_, err = NewTLSDialer(options, nil)
+	require.NotNil(t, err)
+}
+
+func TestNewTLSDialer(t *testing.T) {
+	var suites []uint16
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			suites = hello.CipherSuites
+			return nil, nil
+		},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	options := DefaultClientOptions()
+	options.ClientHello = ClientHelloCustom
+	options.ClientHelloSpec = &ClientHelloSpec{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_AES_128_GCM_SHA256}}
+	dialTLS, err := NewTLSDialer(options, (&net.Dialer{}).DialContext)
+	require.Nil(t, err)
+
+	hc := &http.Client{Transport: &http.Transport{DialTLSContext: dialTLS}}
+	resp, err := hc.Get(ts.URL)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, options.ClientHelloSpec.CipherSuites, suites)
+
+	dialTLS, err = NewTLSDialer(DefaultClientOptions(), nil)
+	require.Nil(t, err)
+	require.Nil(t, dialTLS, "crypto/tls needs no dialer")
+}
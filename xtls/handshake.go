@@ -0,0 +1,94 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// Handshaker tls client handshakes with the ClientHello, root CAs, client
+// certificates and verification of the options, the config is built once.
+// Enforced checks get the dialed host, crypto/tls alone only knows the SNI
+// which ip targets don't send.
+type Handshaker struct {
+	options  *ClientOptions
+	config   *tls.Config
+	selector *certSelector
+	verifier *Verifier
+}
+
+// NewHandshaker handshaker of options
+func NewHandshaker(options *ClientOptions) (*Handshaker, error) {
+	if options.CustomClientHello() {
+		if _, err := options.clientHelloSpec(); err != nil {
+			return nil, err
+		}
+	}
+	config, selector, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := NewVerifier(options)
+	if err != nil {
+		return nil, err
+	}
+	return &Handshaker{options: options, config: config, selector: selector, verifier: verifier}, nil
+}
+
+// ConfigFor copy of config with the enforced checks bound to host
+func (h *Handshaker) ConfigFor(config *tls.Config, host string) *tls.Config {
+	if h == nil {
+		return config
+	}
+	config = config.Clone()
+	if verify := h.verifier.VerifyConnectionFor(host); verify != nil {
+		config.VerifyConnection = verify
+	}
+	return config
+}
+
+// Handshake handshakes conn with host offering nextProtos, with the configured
+// ClientHello when there is one. conn is closed when it fails
+func (h *Handshaker) Handshake(ctx context.Context, conn net.Conn, host string, nextProtos []string) (net.Conn, error) {
+	config := h.ConfigFor(h.config, host)
+	config.NextProtos = nextProtos
+	if h.options.CustomClientHello() {
+		uconn, err := newUConn(ctx, conn, host, h.options, config, nil, h.selector)
+		if err == nil {
+			err = uconn.HandshakeContext(ctx)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return uconn, nil
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// DialTLS http.Transport.DialTLSContext offering nextProtos, nil unless pins or
+// a callback are enforced, the handshake of net/http does just as well then
+func (h *Handshaker) DialTLS(dial func(ctx context.Context, network, addr string) (net.Conn, error), nextProtos []string) DialTLSFunc {
+	if h == nil || h.verifier == nil || h.verifier.reportOnly || (len(h.verifier.rules) == 0 && h.verifier.callback == nil) {
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return h.Handshake(ctx, conn, host, nextProtos)
+	}
+}
@@ -0,0 +1,77 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestHandshaker(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	hostCert := newTestCert(t, "127.0.0.1", ca, false)
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.Nil(t, err)
+
+	type seen struct {
+		suites []uint16
+		protos []string
+		client string
+	}
+	seenCh := make(chan seen, 1)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var s seen
+			tlsConn := tls.Server(conn, &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.RequestClientCert,
+				NextProtos:   []string{"h2", "http/1.1"},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					s.suites, s.protos = hello.CipherSuites, hello.SupportedProtos
+					return nil, nil
+				},
+			})
+			if tlsConn.Handshake() == nil {
+				if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+					s.client = certs[0].Subject.CommonName
+				}
+			}
+			tlsConn.Close()
+			seenCh <- s
+		}
+	}()
+
+	options := DefaultClientOptions()
+	options.ClientHello = ClientHelloCustom
+	options.ClientHelloSpec = &ClientHelloSpec{
+		CipherSuites:        []uint16{0xc02b, 0x1301},
+		Extensions:          []uint16{0, 10, 11, 13, 16, 43, 51},
+		Curves:              []uint16{29, 23},
+		SignatureAlgorithms: []uint16{0x0403, 0x0804},
+	}
+	options.Certificates = []CertConfig{{CertPEM: hostCert.certPEM, KeyPEM: hostCert.keyPEM, Hosts: []string{"127.0.0.1"}}}
+	handshaker, err := NewHandshaker(options)
+	require.Nil(t, err)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	ctx := ContextWithServerName(context.Background(), func() string { return "127.0.0.1" })
+	tlsConn, err := handshaker.Handshake(ctx, conn, "127.0.0.1", []string{"h2"})
+	require.Nil(t, err)
+	defer tlsConn.Close()
+	require.Equal(t, "h2", tlsConn.(*Conn).ConnectionState().NegotiatedProtocol)
+
+	s := <-seenCh
+	require.Equal(t, options.ClientHelloSpec.CipherSuites, s.suites, "the configured ClientHello is sent")
+	require.Equal(t, []string{"h2"}, s.protos, "next protos replace the alpn of the profile")
+	require.Equal(t, "127.0.0.1", s.client, "the certificate of the host is selected")
+}
@@ -0,0 +1,237 @@
+package xtls
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/curve25519"
+	"io"
+	"net"
+)
+
+const (
+	recordTypeAlert     = 21
+	recordTypeHandshake = 22
+
+	handshakeTypeClientHello = 1
+	handshakeTypeServerHello = 2
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extExtendedMasterSec   = 0x0017
+	extSupportedVersions   = 0x002b
+	extKeyShare            = 0x0033
+	extRenegotiationInfo   = 0xff01
+
+	groupX25519 = 0x001d
+
+	maxHandshakeSize = 1 << 16
+)
+
+// errAlert server answered the ClientHello with an alert
+var errAlert = errors.New("tls: server sent alert")
+
+var (
+	helloGroups        = []uint16{groupX25519, 0x0017, 0x0018, 0x0019}
+	helloSignatureAlgs = []uint16{0x0403, 0x0503, 0x0603, 0x0804, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0203, 0x0201}
+)
+
+// serverHello fields of interest, version is the negotiated one (supported_versions aware)
+type serverHello struct {
+	version     uint16
+	cipherSuite uint16
+}
+
+// buildClientHello raw ClientHello record for version offering suites, works for
+// every suite id since nothing past the ServerHello is ever processed
+func buildClientHello(version uint16, suites []uint16, serverName string) ([]byte, error) {
+	helloVersion := version
+	if version >= tls.VersionTLS13 {
+		helloVersion = tls.VersionTLS12
+	}
+
+	body := make([]byte, 0, 512)
+	body = appendUint16(body, helloVersion)
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	body = append(body, random...)
+	if version >= tls.VersionTLS13 {
+		// legacy_session_id for middlebox compatibility
+		sessionID := make([]byte, 32)
+		if _, err := rand.Read(sessionID); err != nil {
+			return nil, err
+		}
+		body = append(body, byte(len(sessionID)))
+		body = append(body, sessionID...)
+	} else {
+		body = append(body, 0)
+	}
+	body = appendUint16(body, uint16(len(suites)*2))
+	for _, suite := range suites {
+		body = appendUint16(body, suite)
+	}
+	body = append(body, 1, 0) // null compression
+
+	if version > tls.VersionSSL30 { // nolint[:staticcheck]
+		ext, err := helloExtensions(version, serverName)
+		if err != nil {
+			return nil, err
+		}
+		body = appendUint16(body, uint16(len(ext)))
+		body = append(body, ext...)
+	}
+
+	handshake := make([]byte, 0, len(body)+4)
+	handshake = append(handshake, handshakeTypeClientHello, byte(len(body)>>16), byte(len(body)>>8), byte(len(body)))
+	handshake = append(handshake, body...)
+
+	recordVersion := uint16(tls.VersionTLS10)
+	if version == tls.VersionSSL30 { // nolint[:staticcheck]
+		recordVersion = version
+	}
+	record := make([]byte, 0, len(handshake)+5)
+	record = append(record, recordTypeHandshake)
+	record = appendUint16(record, recordVersion)
+	record = appendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...), nil
+}
+
+func helloExtensions(version uint16, serverName string) ([]byte, error) {
+	var ext []byte
+	addExt := func(typ uint16, data []byte) {
+		ext = appendUint16(ext, typ)
+		ext = appendUint16(ext, uint16(len(data)))
+		ext = append(ext, data...)
+	}
+
+	if serverName != "" && net.ParseIP(serverName) == nil {
+		var data []byte
+		data = appendUint16(data, uint16(len(serverName)+3))
+		data = append(data, 0) // host_name
+		data = appendUint16(data, uint16(len(serverName)))
+		data = append(data, serverName...)
+		addExt(extServerName, data)
+	}
+
+	groups := appendUint16(nil, uint16(len(helloGroups)*2))
+	for _, g := range helloGroups {
+		groups = appendUint16(groups, g)
+	}
+	addExt(extSupportedGroups, groups)
+	addExt(extECPointFormats, []byte{1, 0})
+
+	if version >= tls.VersionTLS12 {
+		algs := appendUint16(nil, uint16(len(helloSignatureAlgs)*2))
+		for _, alg := range helloSignatureAlgs {
+			algs = appendUint16(algs, alg)
+		}
+		addExt(extSignatureAlgorithms, algs)
+	}
+	addExt(extExtendedMasterSec, nil)
+	addExt(extRenegotiationInfo, []byte{0})
+
+	if version >= tls.VersionTLS13 {
+		addExt(extSupportedVersions, []byte{2, byte(version >> 8), byte(version)})
+
+		private := make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(private); err != nil {
+			return nil, err
+		}
+		public, err := curve25519.X25519(private, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		var share []byte
+		share = appendUint16(share, uint16(len(public)+4))
+		share = appendUint16(share, groupX25519)
+		share = appendUint16(share, uint16(len(public)))
+		share = append(share, public...)
+		addExt(extKeyShare, share)
+	}
+	return ext, nil
+}
+
+// readServerHello reads records until the ServerHello is complete, errAlert when the server refuses
+func readServerHello(r io.Reader) (*serverHello, error) {
+	var handshake []byte
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[3:]))
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		switch header[0] {
+		case recordTypeAlert:
+			return nil, errAlert
+		case recordTypeHandshake:
+		default:
+			return nil, fmt.Errorf("tls: unexpected record type %d", header[0])
+		}
+		handshake = append(handshake, payload...)
+		if len(handshake) < 4 {
+			continue
+		}
+		if handshake[0] != handshakeTypeServerHello {
+			return nil, fmt.Errorf("tls: unexpected handshake message %d", handshake[0])
+		}
+		msgLen := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+		if msgLen > maxHandshakeSize {
+			return nil, errors.New("tls: server hello too large")
+		}
+		if len(handshake) >= msgLen+4 {
+			return parseServerHello(handshake[4 : msgLen+4])
+		}
+	}
+}
+
+func parseServerHello(data []byte) (*serverHello, error) {
+	errMalformed := errors.New("tls: malformed server hello")
+	if len(data) < 2+32+1 {
+		return nil, errMalformed
+	}
+	hello := &serverHello{version: binary.BigEndian.Uint16(data)}
+	data = data[34:]
+	sessionIDLen := int(data[0])
+	if len(data) < 1+sessionIDLen+3 {
+		return nil, errMalformed
+	}
+	data = data[1+sessionIDLen:]
+	hello.cipherSuite = binary.BigEndian.Uint16(data)
+	data = data[3:]
+	if len(data) < 2 {
+		return hello, nil
+	}
+	extLen := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < extLen {
+		return nil, errMalformed
+	}
+	data = data[:extLen]
+	for len(data) >= 4 {
+		typ := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return nil, errMalformed
+		}
+		if typ == extSupportedVersions && length == 2 {
+			hello.version = binary.BigEndian.Uint16(data[4:])
+		}
+		data = data[4+length:]
+	}
+	return hello, nil
+}
+
+// appendUint16 binary.BigEndian.AppendUint16 needs go1.19
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
@@ -0,0 +1,313 @@
+package xtls
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fingerprint JA3 and JA4 of a ClientHello. Browser profiles shuffle their
+// extensions like chrome does, so JA3 changes between connections while JA4 is stable.
+type Fingerprint struct {
+	JA3     string `json:"ja3"`
+	JA3Hash string `json:"ja3_hash"`
+	JA4     string `json:"ja4"`
+}
+
+type clientHelloFields struct {
+	version       uint16
+	cipherSuites  []uint16
+	extensions    []uint16
+	curves        []uint16
+	pointFormats  []uint8
+	sigAlgs       []uint16
+	versions      []uint16
+	alpn          string
+	hasServerName bool
+}
+
+// ClientHelloFingerprint fingerprint of the ClientHello options send to serverName. The
+// crypto/tls hello is captured without the alpn net/http adds for http2.
+func ClientHelloFingerprint(options *ClientOptions, serverName string) (*Fingerprint, error) {
+	raw, err := captureClientHello(options, serverName)
+	if err != nil {
+		return nil, err
+	}
+	return FingerprintClientHello(raw)
+}
+
+// captureClientHello runs the client side of a handshake over a pipe and keeps the first record
+func captureClientHello(options *ClientOptions, serverName string) ([]byte, error) {
+	client, server := net.Pipe()
+	defer server.Close()
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if options.CustomClientHello() {
+		config, selector, err := newTLSConfig(options)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		uconn, err := newUConn(context.Background(), client, serverName, options, config, nil, selector)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		go func() {
+			_ = uconn.Handshake()
+			client.Close()
+		}()
+	} else {
+		config, err := NewTLSConfig(options)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		config.ServerName = serverName
+		go func() {
+			_ = tls.Client(client, config).Handshake()
+			client.Close()
+		}()
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		return nil, err
+	}
+	record := make([]byte, 5+int(binary.BigEndian.Uint16(header[3:])))
+	copy(record, header)
+	if _, err := io.ReadFull(server, record[5:]); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// FingerprintClientHello fingerprint of a raw ClientHello, either the tls record or the handshake message
+func FingerprintClientHello(raw []byte) (*Fingerprint, error) {
+	hello, err := parseClientHello(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &Fingerprint{
+		JA3:     hello.ja3(),
+		JA3Hash: fmt.Sprintf("%x", md5.Sum([]byte(hello.ja3()))),
+		JA4:     hello.ja4(),
+	}, nil
+}
+
+func (h *clientHelloFields) ja3() string {
+	join := func(values []uint16) string {
+		var parts []string
+		for _, v := range values {
+			if !isGREASE(v) {
+				parts = append(parts, strconv.Itoa(int(v)))
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+	var points []string
+	for _, p := range h.pointFormats {
+		points = append(points, strconv.Itoa(int(p)))
+	}
+	return fmt.Sprintf("%d,%s,%s,%s,%s", h.version, join(h.cipherSuites), join(h.extensions), join(h.curves), strings.Join(points, "-"))
+}
+
+func (h *clientHelloFields) ja4() string {
+	version := h.version
+	for _, v := range h.versions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	versionCode := map[uint16]string{
+		tls.VersionTLS13: "13",
+		tls.VersionTLS12: "12",
+		tls.VersionTLS11: "11",
+		tls.VersionTLS10: "10",
+		tls.VersionSSL30: "s3", // nolint[:staticcheck]
+	}[version]
+	if versionCode == "" {
+		versionCode = "00"
+	}
+	sni := "i"
+	if h.hasServerName {
+		sni = "d"
+	}
+
+	var suites, extensions, sigAlgs []string
+	extCount := 0
+	for _, s := range h.cipherSuites {
+		if !isGREASE(s) {
+			suites = append(suites, fmt.Sprintf("%04x", s))
+		}
+	}
+	for _, e := range h.extensions {
+		if isGREASE(e) {
+			continue
+		}
+		extCount++
+		if e != extServerName && e != extALPN {
+			extensions = append(extensions, fmt.Sprintf("%04x", e))
+		}
+	}
+	for _, s := range h.sigAlgs {
+		if !isGREASE(s) {
+			sigAlgs = append(sigAlgs, fmt.Sprintf("%04x", s))
+		}
+	}
+	sort.Strings(suites)
+	sort.Strings(extensions)
+
+	suitesHash := "000000000000"
+	if len(suites) > 0 {
+		suitesHash = ja4Hash(strings.Join(suites, ","))
+	}
+	extensionsHash := "000000000000"
+	if len(extensions) > 0 {
+		s := strings.Join(extensions, ",")
+		if len(sigAlgs) > 0 {
+			s += "_" + strings.Join(sigAlgs, ",")
+		}
+		extensionsHash = ja4Hash(s)
+	}
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s", versionCode, sni, min(len(suites), 99), min(extCount, 99),
+		ja4ALPN(h.alpn), suitesHash, extensionsHash)
+}
+
+func ja4Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ja4ALPN first and last character of the first alpn value, hex digits when not alphanumeric
+func ja4ALPN(alpn string) string {
+	if alpn == "" {
+		return "00"
+	}
+	isAlnum := func(c byte) bool {
+		return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if isAlnum(first) && isAlnum(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString([]byte(alpn))
+	return string([]byte{h[0], h[len(h)-1]})
+}
+
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func parseClientHello(raw []byte) (*clientHelloFields, error) {
+	errMalformed := errors.New("tls: malformed client hello")
+	if len(raw) >= 5 && raw[0] == recordTypeHandshake {
+		raw = raw[5:]
+	}
+	if len(raw) < 4 || raw[0] != handshakeTypeClientHello {
+		return nil, errors.New("tls: not a client hello")
+	}
+	msgLen := int(raw[1])<<16 | int(raw[2])<<8 | int(raw[3])
+	if len(raw) < 4+msgLen {
+		return nil, errMalformed
+	}
+	s := reader(raw[4 : 4+msgLen])
+
+	h := &clientHelloFields{}
+	var random, sessionID, suites, compression, extensions reader
+	if !s.uint16(&h.version) || !s.bytes(&random, 32) || !s.prefixed8(&sessionID) ||
+		!s.prefixed16(&suites) || !s.prefixed8(&compression) {
+		return nil, errMalformed
+	}
+	h.cipherSuites = suites.uint16s()
+	if len(s) == 0 {
+		return h, nil
+	}
+	if !s.prefixed16(&extensions) {
+		return nil, errMalformed
+	}
+	for len(extensions) > 0 {
+		var typ uint16
+		var data reader
+		if !extensions.uint16(&typ) || !extensions.prefixed16(&data) {
+			return nil, errMalformed
+		}
+		h.extensions = append(h.extensions, typ)
+		var list reader
+		switch typ {
+		case extServerName:
+			h.hasServerName = true
+		case extSupportedGroups:
+			if data.prefixed16(&list) {
+				h.curves = list.uint16s()
+			}
+		case extECPointFormats:
+			if data.prefixed8(&list) {
+				h.pointFormats = list
+			}
+		case extSignatureAlgorithms:
+			if data.prefixed16(&list) {
+				h.sigAlgs = list.uint16s()
+			}
+		case extALPN:
+			var proto reader
+			if data.prefixed16(&list) && list.prefixed8(&proto) {
+				h.alpn = string(proto)
+			}
+		case extSupportedVersions:
+			if data.prefixed8(&list) {
+				h.versions = list.uint16s()
+			}
+		}
+	}
+	return h, nil
+}
+
+// reader minimal cryptobyte.String
+type reader []byte
+
+func (r *reader) bytes(out *reader, n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*out, *r = (*r)[:n], (*r)[n:]
+	return true
+}
+
+func (r *reader) uint16(out *uint16) bool {
+	var b reader
+	if !r.bytes(&b, 2) {
+		return false
+	}
+	*out = binary.BigEndian.Uint16(b)
+	return true
+}
+
+func (r *reader) prefixed8(out *reader) bool {
+	var n reader
+	return r.bytes(&n, 1) && r.bytes(out, int(n[0]))
+}
+
+func (r *reader) prefixed16(out *reader) bool {
+	var n uint16
+	return r.uint16(&n) && r.bytes(out, int(n))
+}
+
+func (r reader) uint16s() []uint16 {
+	var values []uint16
+	for i := 0; i+1 < len(r); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(r[i:]))
+	}
+	return values
+}
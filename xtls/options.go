@@ -7,13 +7,37 @@ import (
 type PKCS12Config struct {
 	Path     string
 	Password string
+	Data     []byte `json:"-" yaml:"-"` // in-memory bundle, takes precedence over Path
+}
+
+// CertConfig PEM client certificate, from files or in-memory bytes
+type CertConfig struct {
+	CertFile    string `json:"cert_file" yaml:"cert_file"`
+	KeyFile     string `json:"key_file" yaml:"key_file"` // empty when the key is in CertFile
+	KeyPassword string `json:"key_password" yaml:"key_password"`
+	CertPEM     []byte `json:"-" yaml:"-"`
+	KeyPEM      []byte `json:"-" yaml:"-"`
+	// Hosts the certificate is presented to, supports wildcards like *.example.com,
+	// empty means any host without a dedicated certificate
+	Hosts []string `json:"hosts" yaml:"hosts"`
 }
 
 type ClientOptions struct {
 	PKCS12        PKCS12Config `json:"pkcs12" yaml:"pkcs12"`
+	Certificates  []CertConfig `json:"certificates" yaml:"certificates"`
+	RootCAFiles   []string     `json:"root_ca_files" yaml:"root_ca_files"`
+	RootCAPEM     []byte       `json:"-" yaml:"-"`
+	NoSystemRoots bool         `json:"no_system_roots" yaml:"no_system_roots"` // only trust RootCAFiles and RootCAPEM
 	TLSSkipVerify bool         `json:"-" yaml:"-"`
 	TLSMinVersion uint16       `json:"-" yaml:"-"`
 	TLSMaxVersion uint16       `json:"-" yaml:"-"`
+	// Pins host (supports wildcards, "*" for every host) to sha256/<base64 spki> or cert-sha256/<hex> pins
+	Pins                map[string][]string `json:"pins" yaml:"pins"`
+	VerifyCallback      VerifyCallback      `json:"-" yaml:"-"`
+	TLSVerifyReportOnly bool                `json:"verify_report_only" yaml:"verify_report_only"` // verify but don't fail, see Verifier
+	// ClientHello profile, see ClientHelloGo and friends
+	ClientHello     string           `json:"client_hello" yaml:"client_hello"`
+	ClientHelloSpec *ClientHelloSpec `json:"client_hello_spec" yaml:"client_hello_spec"` // used by ClientHelloCustom
 }
 
 func DefaultClientOptions() *ClientOptions {
@@ -23,3 +47,24 @@ func DefaultClientOptions() *ClientOptions {
 		TLSMaxVersion: tls.VersionTLS13,
 	}
 }
+
+// Clone deep copies slices, in-memory PEM bytes are shared
+func (o *ClientOptions) Clone() *ClientOptions {
+	newOptions := *o
+	newOptions.RootCAFiles = append([]string(nil), o.RootCAFiles...)
+	newOptions.Certificates = make([]CertConfig, len(o.Certificates))
+	for i, c := range o.Certificates {
+		c.Hosts = append([]string(nil), c.Hosts...)
+		newOptions.Certificates[i] = c
+	}
+	if o.ClientHelloSpec != nil {
+		newOptions.ClientHelloSpec = o.ClientHelloSpec.Clone()
+	}
+	if o.Pins != nil {
+		newOptions.Pins = make(map[string][]string, len(o.Pins))
+		for host, pins := range o.Pins {
+			newOptions.Pins[host] = append([]string(nil), pins...)
+		}
+	}
+	return &newOptions
+}
@@ -0,0 +1,213 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ScanOptions tls version and cipher suite enumeration, every probe is a raw ClientHello
+// so legacy suites crypto/tls doesn't implement are covered too, SSLv2 is not probed
+type ScanOptions struct {
+	Timeout    int      `json:"timeout" yaml:"timeout"`         // seconds per probe
+	ServerName string   `json:"server_name" yaml:"server_name"` // sni, not sent for ip addresses
+	Versions   []uint16 `json:"versions" yaml:"versions"`
+	// DialContext defaults to net.Dialer
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error) `json:"-" yaml:"-"`
+}
+
+func DefaultScanOptions() *ScanOptions {
+	return &ScanOptions{
+		Timeout: 5,
+		Versions: []uint16{
+			tls.VersionSSL30, // nolint[:staticcheck]
+			tls.VersionTLS10,
+			tls.VersionTLS11,
+			tls.VersionTLS12,
+			tls.VersionTLS13,
+		},
+	}
+}
+
+// VersionSupport suites accepted for a protocol version
+type VersionSupport struct {
+	Version uint16 `json:"version"`
+	Name    string `json:"name"`
+	// CipherSuites in server preference order when ServerPreference, offer order otherwise
+	CipherSuites     []CipherSuite `json:"cipher_suites"`
+	ServerPreference bool          `json:"server_preference"`
+}
+
+// Weakness finding of a scan, CipherSuite is empty for protocol version weaknesses
+type Weakness struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	Description string `json:"description"`
+}
+
+type ScanResult struct {
+	Address    string            `json:"address"`
+	Supported  []*VersionSupport `json:"supported"` // oldest version first
+	Weaknesses []*Weakness       `json:"weaknesses"`
+}
+
+// SupportsVersion version accepted with at least one suite
+func (r *ScanResult) SupportsVersion(version uint16) bool {
+	for _, v := range r.Supported {
+		if v.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+type scanner struct {
+	address    string
+	serverName string
+	timeout    time.Duration
+	dial       func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Scan enumerates the versions and cipher suites address (host:port) accepts
+func Scan(ctx context.Context, address string, options *ScanOptions) (*ScanResult, error) {
+	if options == nil {
+		options = DefaultScanOptions()
+	}
+	s := &scanner{
+		address:    address,
+		serverName: options.ServerName,
+		timeout:    time.Duration(options.Timeout) * time.Second,
+		dial:       options.DialContext,
+	}
+	if s.serverName == "" {
+		s.serverName, _, _ = net.SplitHostPort(address)
+	}
+	if s.timeout <= 0 {
+		s.timeout = 5 * time.Second
+	}
+	if s.dial == nil {
+		s.dial = (&net.Dialer{}).DialContext
+	}
+
+	result := &ScanResult{Address: address}
+	for _, version := range options.Versions {
+		support, err := s.scanVersion(ctx, version)
+		if err != nil {
+			return nil, err
+		}
+		if support == nil {
+			continue
+		}
+		result.Supported = append(result.Supported, support)
+		result.Weaknesses = append(result.Weaknesses, versionWeaknesses(support)...)
+	}
+	return result, nil
+}
+
+func (s *scanner) scanVersion(ctx context.Context, version uint16) (*VersionSupport, error) {
+	var offered []uint16
+	for _, suite := range CipherSuites(version) {
+		offered = append(offered, suite.ID)
+	}
+
+	var found []uint16
+	for len(offered) > 0 {
+		hello, err := s.probe(ctx, version, offered)
+		if err != nil {
+			return nil, err
+		}
+		if hello == nil {
+			break
+		}
+		found = append(found, hello.cipherSuite)
+		offered = removeSuite(offered, hello.cipherSuite)
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	support := &VersionSupport{Version: version, Name: VersionName(version)}
+	if len(found) > 1 {
+		// the server picking its favourite from the reversed offer means it enforces its own order
+		hello, err := s.probe(ctx, version, []uint16{found[1], found[0]})
+		if err != nil {
+			return nil, err
+		}
+		support.ServerPreference = hello != nil && hello.cipherSuite == found[0]
+	}
+	for _, id := range found {
+		support.CipherSuites = append(support.CipherSuites, CipherSuite{ID: id, Name: CipherSuiteName(id)})
+	}
+	return support, nil
+}
+
+// probe nil hello when the server refuses version or suites, error only when it can't be reached
+func (s *scanner) probe(ctx context.Context, version uint16, suites []uint16) (*serverHello, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	conn, err := s.dial(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, fmt.Errorf("tls scan %s: %w", s.address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	record, err := buildClientHello(version, suites, s.serverName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(record); err != nil {
+		return nil, nil
+	}
+	hello, err := readServerHello(conn)
+	if err != nil {
+		// alert, reset or garbage, a cancelled scan is the only failure
+		if ctx.Err() != nil && ctx.Err() != context.DeadlineExceeded {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+	if hello.version != version || !containsSuite(suites, hello.cipherSuite) {
+		return nil, nil
+	}
+	return hello, nil
+}
+
+func versionWeaknesses(support *VersionSupport) []*Weakness {
+	var weaknesses []*Weakness
+	switch support.Version {
+	case tls.VersionSSL30: // nolint[:staticcheck]
+		weaknesses = append(weaknesses, &Weakness{Version: support.Name, Description: "SSLv3 supported (POODLE)"})
+	case tls.VersionTLS10, tls.VersionTLS11:
+		weaknesses = append(weaknesses, &Weakness{Version: support.Name, Description: "deprecated protocol version (RFC 8996)"})
+	}
+	for _, suite := range support.CipherSuites {
+		if reason := cipherWeakness(suite, support.Version); reason != "" {
+			weaknesses = append(weaknesses, &Weakness{Version: support.Name, CipherSuite: suite.Name, Description: reason})
+		}
+	}
+	return weaknesses
+}
+
+func containsSuite(suites []uint16, id uint16) bool {
+	for _, suite := range suites {
+		if suite == id {
+			return true
+		}
+	}
+	return false
+}
+
+func removeSuite(suites []uint16, id uint16) []uint16 {
+	var left []uint16
+	for _, suite := range suites {
+		if suite != id {
+			left = append(left, suite)
+		}
+	}
+	return left
+}
@@ -0,0 +1,92 @@
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func newScanTestServer(t *testing.T, config *tls.Config) string {
+	leaf := newTestCert(t, "server", nil, false)
+	cert, err := tls.X509KeyPair(leaf.certPEM, leaf.keyPEM)
+	require.Nil(t, err)
+	config.Certificates = []tls.Certificate{cert}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestScan(t *testing.T) {
+	addr := newScanTestServer(t, &tls.Config{
+		MinVersion: tls.VersionTLS11,
+		MaxVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+		},
+	})
+
+	result, err := Scan(context.Background(), addr, nil)
+	require.Nil(t, err)
+	require.False(t, result.SupportsVersion(tls.VersionTLS10))
+	require.False(t, result.SupportsVersion(tls.VersionTLS13))
+	require.Len(t, result.Supported, 2)
+
+	suiteNames := func(v *VersionSupport) []string {
+		var names []string
+		for _, s := range v.CipherSuites {
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	require.Equal(t, "TLS 1.1", result.Supported[0].Name)
+	require.ElementsMatch(t, []string{"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA", "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA"}, suiteNames(result.Supported[0]))
+	require.Equal(t, "TLS 1.2", result.Supported[1].Name)
+	require.ElementsMatch(t, []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+		"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	}, suiteNames(result.Supported[1]))
+
+	var descriptions []string
+	for _, w := range result.Weaknesses {
+		descriptions = append(descriptions, w.Version+" "+w.CipherSuite+" "+w.Description)
+	}
+	require.Contains(t, descriptions, "TLS 1.1  deprecated protocol version (RFC 8996)")
+	require.Contains(t, descriptions, "TLS 1.2 TLS_ECDHE_ECDSA_WITH_RC4_128_SHA RC4 stream cipher")
+}
+
+func TestScan_TLS13(t *testing.T) {
+	addr := newScanTestServer(t, &tls.Config{MinVersion: tls.VersionTLS13})
+	result, err := Scan(context.Background(), addr, nil)
+	require.Nil(t, err)
+	require.Len(t, result.Supported, 1)
+	require.Equal(t, uint16(tls.VersionTLS13), result.Supported[0].Version)
+	require.Len(t, result.Supported[0].CipherSuites, 3)
+	require.Empty(t, result.Weaknesses)
+}
+
+func TestScan_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+	_, err = Scan(context.Background(), addr, nil)
+	require.NotNil(t, err)
+}
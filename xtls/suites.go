@@ -0,0 +1,151 @@
+package xtls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// CipherSuite iana cipher suite, including legacy ones crypto/tls doesn't implement
+type CipherSuite struct {
+	ID         uint16 `json:"id"`
+	Name       string `json:"name"`
+	MinVersion uint16 `json:"-"` // tls 1.2 only suites (aead, sha256/384 mac) or tls 1.3
+	TLS13      bool   `json:"-"`
+}
+
+var cipherSuites = []CipherSuite{
+	// tls 1.3
+	{ID: 0x1301, Name: "TLS_AES_128_GCM_SHA256", TLS13: true},
+	{ID: 0x1302, Name: "TLS_AES_256_GCM_SHA384", TLS13: true},
+	{ID: 0x1303, Name: "TLS_CHACHA20_POLY1305_SHA256", TLS13: true},
+	{ID: 0x1304, Name: "TLS_AES_128_CCM_SHA256", TLS13: true},
+	{ID: 0x1305, Name: "TLS_AES_128_CCM_8_SHA256", TLS13: true},
+
+	// aead, tls 1.2 only
+	{ID: 0xc02b, Name: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xc02c, Name: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0xc02f, Name: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xc030, Name: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0xcca9, Name: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xcca8, Name: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xccaa, Name: "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x009e, Name: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x009f, Name: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0x009c, Name: "TLS_RSA_WITH_AES_128_GCM_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x009d, Name: "TLS_RSA_WITH_AES_256_GCM_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0xc023, Name: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xc024, Name: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0xc027, Name: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0xc028, Name: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384", MinVersion: tls.VersionTLS12},
+	{ID: 0x0067, Name: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x006b, Name: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x003c, Name: "TLS_RSA_WITH_AES_128_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x003d, Name: "TLS_RSA_WITH_AES_256_CBC_SHA256", MinVersion: tls.VersionTLS12},
+	{ID: 0x003b, Name: "TLS_RSA_WITH_NULL_SHA256", MinVersion: tls.VersionTLS12},
+
+	// cbc
+	{ID: 0xc009, Name: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA"},
+	{ID: 0xc00a, Name: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA"},
+	{ID: 0xc013, Name: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA"},
+	{ID: 0xc014, Name: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA"},
+	{ID: 0x0033, Name: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA"},
+	{ID: 0x0039, Name: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA"},
+	{ID: 0x002f, Name: "TLS_RSA_WITH_AES_128_CBC_SHA"},
+	{ID: 0x0035, Name: "TLS_RSA_WITH_AES_256_CBC_SHA"},
+	{ID: 0x0041, Name: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA"},
+	{ID: 0x0084, Name: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA"},
+	{ID: 0x0096, Name: "TLS_RSA_WITH_SEED_CBC_SHA"},
+	{ID: 0x0007, Name: "TLS_RSA_WITH_IDEA_CBC_SHA"},
+
+	// legacy
+	{ID: 0xc008, Name: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA"},
+	{ID: 0xc012, Name: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"},
+	{ID: 0x0016, Name: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA"},
+	{ID: 0x000a, Name: "TLS_RSA_WITH_3DES_EDE_CBC_SHA"},
+	{ID: 0xc007, Name: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA"},
+	{ID: 0xc011, Name: "TLS_ECDHE_RSA_WITH_RC4_128_SHA"},
+	{ID: 0x0005, Name: "TLS_RSA_WITH_RC4_128_SHA"},
+	{ID: 0x0004, Name: "TLS_RSA_WITH_RC4_128_MD5"},
+	{ID: 0x0015, Name: "TLS_DHE_RSA_WITH_DES_CBC_SHA"},
+	{ID: 0x0009, Name: "TLS_RSA_WITH_DES_CBC_SHA"},
+	{ID: 0x0014, Name: "TLS_DHE_RSA_EXPORT_WITH_DES40_CBC_SHA"},
+	{ID: 0x0008, Name: "TLS_RSA_EXPORT_WITH_DES40_CBC_SHA"},
+	{ID: 0x0006, Name: "TLS_RSA_EXPORT_WITH_RC2_CBC_40_MD5"},
+	{ID: 0x0003, Name: "TLS_RSA_EXPORT_WITH_RC4_40_MD5"},
+	{ID: 0xc006, Name: "TLS_ECDHE_ECDSA_WITH_NULL_SHA"},
+	{ID: 0xc010, Name: "TLS_ECDHE_RSA_WITH_NULL_SHA"},
+	{ID: 0x0002, Name: "TLS_RSA_WITH_NULL_SHA"},
+	{ID: 0x0001, Name: "TLS_RSA_WITH_NULL_MD5"},
+	{ID: 0xc018, Name: "TLS_ECDH_anon_WITH_AES_128_CBC_SHA"},
+	{ID: 0xc019, Name: "TLS_ECDH_anon_WITH_AES_256_CBC_SHA"},
+	{ID: 0x0034, Name: "TLS_DH_anon_WITH_AES_128_CBC_SHA"},
+	{ID: 0x003a, Name: "TLS_DH_anon_WITH_AES_256_CBC_SHA"},
+	{ID: 0x001b, Name: "TLS_DH_anon_WITH_3DES_EDE_CBC_SHA"},
+	{ID: 0x0018, Name: "TLS_DH_anon_WITH_RC4_128_MD5"},
+}
+
+// CipherSuites known suites usable with version, in default offer order
+func CipherSuites(version uint16) []CipherSuite {
+	var suites []CipherSuite
+	for _, suite := range cipherSuites {
+		if (version == tls.VersionTLS13) != suite.TLS13 || version < suite.MinVersion {
+			continue
+		}
+		suites = append(suites, suite)
+	}
+	return suites
+}
+
+// CipherSuiteName iana name of id, hex when unknown
+func CipherSuiteName(id uint16) string {
+	for _, suite := range cipherSuites {
+		if suite.ID == id {
+			return suite.Name
+		}
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// cipherWeakness reason the suite is considered weak, empty when it isn't
+func cipherWeakness(suite CipherSuite, version uint16) string {
+	name := suite.Name
+	switch {
+	case strings.Contains(name, "_anon_"):
+		return "anonymous key exchange, no authentication"
+	case strings.Contains(name, "_NULL_"):
+		return "no encryption"
+	case strings.Contains(name, "EXPORT"):
+		return "export grade encryption (FREAK/LOGJAM)"
+	case strings.Contains(name, "_RC4_"):
+		return "RC4 stream cipher"
+	case strings.Contains(name, "_DES_CBC_") || strings.Contains(name, "_DES40_"):
+		return "single DES"
+	case strings.Contains(name, "_3DES_"):
+		return "64-bit block cipher (SWEET32)"
+	case strings.Contains(name, "_MD5"):
+		return "MD5 mac"
+	case strings.Contains(name, "_CBC_") && version <= tls.VersionTLS10:
+		return "CBC mode with predictable IV (BEAST)"
+	case strings.HasPrefix(name, "TLS_RSA_"):
+		return "static RSA key exchange, no forward secrecy"
+	}
+	return ""
+}
+
+// VersionName tls.VersionName needs go1.21
+func VersionName(version uint16) string {
+	switch version {
+	case tls.VersionSSL30: // nolint[:staticcheck]
+		return "SSLv3"
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
@@ -0,0 +1,194 @@
+package xtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPinMismatch no certificate of the peer chain matches the pins of the host
+var ErrPinMismatch = errors.New("tls: certificate pin mismatch")
+
+// VerifyCallback called with the peer chain and the result of the built-in
+// checks, returning an error fails the handshake (or is recorded in report only mode)
+type VerifyCallback func(host string, chain []*x509.Certificate, result *VerifyResult) error
+
+// VerifyResult outcome of the identity checks of a tls connection
+type VerifyResult struct {
+	ChainVerified bool  // chain and host name valid against the root CAs
+	Pinned        bool  // pins are configured for the host
+	PinMatched    bool  // a certificate of the chain matched a pin
+	Err           error // first failed check, nil when every enabled check passed
+}
+
+type pin struct {
+	spki bool // SubjectPublicKeyInfo hash, whole certificate hash otherwise
+	hash []byte
+}
+
+type pinRule struct {
+	host string
+	pins []pin
+}
+
+// Verifier checks chain, pins and callback of a tls connection
+type Verifier struct {
+	roots       *x509.CertPool
+	verifyChain bool
+	reportOnly  bool
+	rules       []pinRule
+	callback    VerifyCallback
+}
+
+// NewVerifier returns nil when no verification is enabled in options
+func NewVerifier(options *ClientOptions) (*Verifier, error) {
+	if options.TLSSkipVerify && !options.TLSVerifyReportOnly && len(options.Pins) == 0 && options.VerifyCallback == nil {
+		return nil, nil
+	}
+	roots, err := loadRootCAs(options)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{
+		roots:       roots,
+		verifyChain: !options.TLSSkipVerify || options.TLSVerifyReportOnly,
+		reportOnly:  options.TLSVerifyReportOnly,
+		callback:    options.VerifyCallback,
+	}
+	for host, values := range options.Pins {
+		rule := pinRule{host: strings.ToLower(host)}
+		for _, value := range values {
+			p, err := parsePin(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.pins = append(rule.pins, p)
+		}
+		v.rules = append(v.rules, rule)
+	}
+	return v, nil
+}
+
+// ReportOnly verification results are recorded instead of failing the handshake
+func (v *Verifier) ReportOnly() bool {
+	return v.reportOnly
+}
+
+// Verify runs every enabled check against cs, host is the name the client connected to
+func (v *Verifier) Verify(host string, cs tls.ConnectionState) *VerifyResult {
+	result := &VerifyResult{}
+	chain := cs.PeerCertificates
+	if len(chain) == 0 {
+		result.Err = errors.New("tls: no peer certificate")
+		return result
+	}
+
+	if len(cs.VerifiedChains) > 0 {
+		result.ChainVerified = true
+	} else if v.verifyChain {
+		opts := x509.VerifyOptions{
+			Roots:         v.roots,
+			DNSName:       host,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range chain[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := chain[0].Verify(opts); err != nil {
+			result.Err = err
+		} else {
+			result.ChainVerified = true
+		}
+	}
+
+	if pins := v.pinsFor(strings.ToLower(host)); len(pins) > 0 {
+		result.Pinned = true
+		result.PinMatched = matchPins(pins, chain)
+		if !result.PinMatched && result.Err == nil {
+			result.Err = fmt.Errorf("%w for %s", ErrPinMismatch, host)
+		}
+	}
+
+	if v.callback != nil {
+		if err := v.callback(host, chain, result); err != nil && result.Err == nil {
+			result.Err = err
+		}
+	}
+	return result
+}
+
+// verifyConnection tls.Config.VerifyConnection of handshakes whose host is
+// unknown, the chain is verified by crypto/tls itself. Ip targets send no SNI,
+// only pins for "*" apply to them, see VerifyConnectionFor.
+func (v *Verifier) verifyConnection(cs tls.ConnectionState) error {
+	return v.Verify(cs.ServerName, cs).Err
+}
+
+// VerifyConnectionFor tls.Config.VerifyConnection checking the connection to
+// host, the host that was dialed, nil for a nil or report only verifier
+func (v *Verifier) VerifyConnectionFor(host string) func(tls.ConnectionState) error {
+	if v == nil || v.reportOnly {
+		return nil
+	}
+	return func(cs tls.ConnectionState) error {
+		return v.Verify(host, cs).Err
+	}
+}
+
+func (v *Verifier) pinsFor(host string) []pin {
+	var pins []pin
+	for _, rule := range v.rules {
+		if matchHost([]string{rule.host}, host) {
+			pins = append(pins, rule.pins...)
+		}
+	}
+	return pins
+}
+
+func matchPins(pins []pin, chain []*x509.Certificate) bool {
+	for _, cert := range chain {
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		raw := sha256.Sum256(cert.Raw)
+		for _, p := range pins {
+			if p.spki && string(p.hash) == string(spki[:]) {
+				return true
+			}
+			if !p.spki && string(p.hash) == string(raw[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parsePin "sha256/<base64 spki hash>" (curl --pinnedpubkey format) or "cert-sha256/<hex certificate hash>"
+func parsePin(value string) (pin, error) {
+	var (
+		p   pin
+		err error
+	)
+	switch {
+	case strings.HasPrefix(value, "sha256/"):
+		p.spki = true
+		p.hash, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "sha256/"))
+	case strings.HasPrefix(value, "cert-sha256/"):
+		p.hash, err = hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(value, "cert-sha256/"), ":", ""))
+	default:
+		return p, fmt.Errorf("invalid pin %s, want sha256/<base64> or cert-sha256/<hex>", value)
+	}
+	if err != nil || len(p.hash) != sha256.Size {
+		return p, fmt.Errorf("invalid pin %s", value)
+	}
+	return p, nil
+}
+
+// SPKIPin pin of cert in the sha256/<base64> format
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,88 @@
+package xtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newPinTestServer(t *testing.T, leaf *testCert) *httptest.Server {
+	serverCert, err := tls.X509KeyPair(leaf.certPEM, leaf.keyPEM)
+	require.Nil(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	ts.StartTLS()
+	return ts
+}
+
+func TestVerifier_Pins(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	leaf := newTestCert(t, "server", ca, false)
+	ts := newPinTestServer(t, leaf)
+	defer ts.Close()
+
+	other := newTestCert(t, "other", nil, false)
+	for pin, ok := range map[string]bool{
+		SPKIPin(leaf.cert):  true,
+		SPKIPin(other.cert): false,
+	} {
+		options := DefaultClientOptions()
+		options.TLSSkipVerify = false
+		options.RootCAPEM = ca.certPEM
+		// ip targets send no sni, only the wildcard host applies
+		options.Pins = map[string][]string{"*": {pin}}
+		config, err := NewTLSConfig(options)
+		require.Nil(t, err)
+		hc := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := hc.Get(ts.URL)
+		if ok {
+			require.Nil(t, err)
+			resp.Body.Close()
+		} else {
+			require.True(t, errors.Is(err, ErrPinMismatch), err)
+		}
+	}
+
+	_, err := NewVerifier(&ClientOptions{Pins: map[string][]string{"*": {"md5/abc"}}})
+	require.NotNil(t, err)
+}
+
+func TestVerifier_ReportOnly(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	leaf := newTestCert(t, "server", ca, false)
+	ts := newPinTestServer(t, leaf)
+	defer ts.Close()
+
+	var called bool
+	options := DefaultClientOptions()
+	options.TLSVerifyReportOnly = true
+	options.NoSystemRoots = true
+	options.RootCAPEM = newTestCert(t, "unrelated", nil, true).certPEM
+	options.Pins = map[string][]string{"127.0.0.1": {SPKIPin(ca.cert)}}
+	options.VerifyCallback = func(host string, chain []*x509.Certificate, result *VerifyResult) error {
+		called = true
+		require.Equal(t, "127.0.0.1", host)
+		require.Equal(t, "server", chain[0].Subject.CommonName)
+		return nil
+	}
+	config, err := NewTLSConfig(options)
+	require.Nil(t, err)
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	resp, err := hc.Get(ts.URL)
+	require.Nil(t, err, "report only must not fail the handshake")
+	resp.Body.Close()
+	require.False(t, called, "report only checks run per response")
+
+	verifier, err := NewVerifier(options)
+	require.Nil(t, err)
+	result := verifier.Verify("127.0.0.1", *resp.TLS)
+	require.True(t, called)
+	require.False(t, result.ChainVerified)
+	require.True(t, result.Pinned)
+	require.False(t, result.PinMatched)
+	require.NotNil(t, result.Err)
+}